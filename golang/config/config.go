@@ -1,71 +1,367 @@
 package config
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
+
+	"example.com/play/repo/wecom/keyring"
+	"example.com/play/utils/redact"
 )
 
-// Config 服务全局配置
-var Config GlobalConfig
+// current 当前生效的全局配置，重载时整体替换
+var current atomic.Pointer[GlobalConfig]
+
+// configFile 配置文件路径，由 -config 或 CONFIG_FILE 指定，运行期间不变
+var configFile string
+
+const (
+	defaultLKEWorkers   = 8
+	defaultLKEQueueSize = 100
+	// defaultShutdownTimeout 默认退出时等待回答完成的秒数
+	defaultShutdownTimeout = 60
+	// 回调服务的默认超时秒数，企业微信要求 5 秒内响应回调
+	defaultHTTPReadTimeout  = 10
+	defaultHTTPWriteTimeout = 30
+	defaultHTTPIdleTimeout  = 120
+	// defaultMaxBodyBytes 回调请求体的默认最大字节数，回调消息为加密后的 XML，通常只有几 KB
+	defaultMaxBodyBytes = 1 << 20
+)
 
-// GlobalConfig 全局配置结构体
+// GlobalConfig 全局配置结构体，各配置项的名称、命令行参数和环境变量见 settings
 type GlobalConfig struct {
+	Listen                string // 回调服务的监听地址，默认 :80，开启 TLS 时默认 :443
+	TLSCertFile           string // TLS 证书文件，设置后以 HTTPS 提供回调服务，文件修改后自动重新加载
+	TLSKeyFile            string // TLS 私钥文件
+	AdminListen           string // 内部管理服务的监听地址，提供 /healthz 和 /reload，为空时不启动
+	AdminClientCAFile     string // 校验管理服务客户端证书的 CA 文件，设置后管理服务要求双向 TLS
+	HTTPReadTimeout       int    // 读取整个请求的最长秒数
+	HTTPWriteTimeout      int    // 写入响应的最长秒数
+	HTTPIdleTimeout       int    // 空闲连接保持的最长秒数
+	MaxBodyBytes          int    // 回调请求体的最大字节数
 	WxToken               string
 	WxEncodingAESKey      string
 	WxCorpID              string
 	WxAppSecret           string
 	TencentCloudLKEAppKey string
+	WxKeysFile            string // 额外有效的 Token/EncodingAESKey 列表文件，用于密钥轮换
+	PassiveReply          bool   // 是否对快速回复（指令、常见问题、错误提示）使用被动回复
+	FAQFile               string // 常见问题固定回答文件，JSON格式：{"问题": "回答"}
+	LKEWorkers            int    // 同时调用大模型知识引擎的最大并发数
+	LKEQueueSize          int    // 等待调用大模型知识引擎的最大排队数
+	DebounceSeconds       int    // 合并同一用户在该秒数内连续发送的消息，0 表示不合并
+	ShutdownTimeout       int    // 退出时等待正在进行的回答完成的最长秒数
+	LKERenderer           string // 大模型知识引擎回答的渲染方式：default、compact、verbose、answer_only
+	LKEThoughtMode        string // 思考过程的展示方式：full、notice、collapsed、hidden，为空时由渲染方式决定
+	LKEChunking           string // 流式回答的切分策略：paragraph[:N]、interval:N、size:N、final
+	LKEReferenceTemplate  string // 引用和参考资料的展示模板文件，text/template 格式
+	LKELinkStrip          bool   // 参考资料去除全部链接，只展示资料名称
+	LKELinkRewrite        string // 参考资料链接的改写模板，如 https://portal.example.com/docs/{{.DocBizID}}
+	LKELinkDomains        string // 参考资料链接允许的域名，逗号分隔，为空时不限制
+	LKESourceAuto         bool   // 回答结束后自动以文件消息发送引用的文档原文
+	TencentCloudSecretID  string // 腾讯云 API 密钥 SecretId，用于调用大模型知识引擎管理接口
+	TencentCloudSecretKey string // 腾讯云 API 密钥 SecretKey
+	TencentCloudRegion    string // 大模型知识引擎管理接口的地域，默认 ap-guangzhou
+	WxTokenCache          string // 多副本共享 access token 的缓存：file:<目录> 或 redis://[:密码@]host:port[/db]
+	WxAgentID             int64  // 应用ID，设置后校验回调消息中的 AgentID，0 表示不校验
+	LKESystemRole         string // 大模型知识引擎的角色指令，为空时使用应用设置
+	ProfilesFile          string // 多应用配置文件，JSON格式，设置后忽略上面单个应用的回调密钥、应用凭证和知识引擎配置
+	WxSuiteID             string // 第三方应用的 SuiteID，设置后以服务商模式运行，无需企业ID和应用 Secret
+	WxSuiteSecret         string // 第三方应用的 Secret
+	WxSuitePath           string // 第三方应用的指令回调路径
+	WxSuiteStore          string // 保存 suite_ticket 和授权企业永久授权码的目录
+
+	File    string            // 配置文件路径
+	Apps    []Profile         // 配置文件中的多应用配置，与 ProfilesFile 二选一
+	Replies map[string]string // 配置文件中覆盖默认提示文案，仅用于未配置多应用时的单个应用
+
+	// Profiles 全部应用配置，由 Load 读取并校验
+	Profiles []Profile
+
+	// sources 各配置项的来源，key 为配置项名称
+	sources map[string]string
+	// invalid 读取或解析出错的配置项，校验时不再重复报错
+	invalid map[string]bool
 }
 
-// IsValid 校验配置项是否都有数据
-func (c *GlobalConfig) IsValid() bool {
-	if c.WxToken == "" || c.WxEncodingAESKey == "" || c.WxCorpID == "" || c.WxAppSecret == "" ||
-		c.TencentCloudLKEAppKey == "" {
-		return false
-	}
-	return true
+// Profile 一个企业微信自建应用及其对接的大模型知识引擎应用
+type Profile struct {
+	Name                 string            `json:"name" yaml:"name"`
+	Path                 string            `json:"path" yaml:"path"`                               // 回调路径，如 /hr，为空时接收任意路径的回调（仅限单个应用）
+	WxToken              string            `json:"wx_token" yaml:"wx_token"`                       // 接收消息配置中的 Token
+	WxTokenFile          string            `json:"wx_token_file" yaml:"wx_token_file"`             // 保存 Token 的文件，与 wx_token 二选一，其他以 _file 结尾的字段同理
+	WxEncodingAESKey     string            `json:"wx_encoding_aes_key" yaml:"wx_encoding_aes_key"` // 接收消息配置中的 EncodingAESKey
+	WxEncodingAESKeyFile string            `json:"wx_encoding_aes_key_file" yaml:"wx_encoding_aes_key_file"`
+	WxKeysFile           string            `json:"wx_keys_file" yaml:"wx_keys_file"` // 额外有效的 Token/EncodingAESKey 列表文件，用于密钥轮换
+	WxCorpID             string            `json:"wx_corp_id" yaml:"wx_corp_id"`     // 企业ID，为空时使用全局配置
+	WxAppSecret          string            `json:"wx_app_secret" yaml:"wx_app_secret"`
+	WxAppSecretFile      string            `json:"wx_app_secret_file" yaml:"wx_app_secret_file"`
+	WxAgentID            int64             `json:"wx_agent_id" yaml:"wx_agent_id"` // 应用ID，回调消息中的 AgentID 必须一致，多个应用时必填
+	LKEAppKey            string            `json:"lke_app_key" yaml:"lke_app_key"` // 大模型知识引擎应用的 BotAppKey
+	LKEAppKeyFile        string            `json:"lke_app_key_file" yaml:"lke_app_key_file"`
+	LKESystemRole        string            `json:"lke_system_role" yaml:"lke_system_role"` // 大模型知识引擎的角色指令，为空时使用应用设置
+	LKEAppName           string            `json:"lke_app_name" yaml:"lke_app_name"`       // 知识库名称，配置了 routes 时在回答末尾注明，为空时使用应用名称
+	LKERenderer          string            `json:"lke_renderer" yaml:"lke_renderer"`       // 回答的渲染方式，为空时使用全局配置
+	LKEChunking          string            `json:"lke_chunking" yaml:"lke_chunking"`       // 流式回答的切分策略，为空时使用全局配置
+	Routes               []Route           `json:"routes" yaml:"routes"`                   // 按问题前缀、关键词或用户部门将问题转给其他知识引擎应用
+	Replies              map[string]string `json:"replies" yaml:"replies"`                 // 覆盖默认的提示文案，key 为文案名称
+	WxSuiteID            string            `json:"wx_suite_id" yaml:"wx_suite_id"`         // 第三方应用的 SuiteID，设置后该应用为服务商模式，回调路径接收各授权企业的消息
+	WxSuiteSecret        string            `json:"wx_suite_secret" yaml:"wx_suite_secret"` // 第三方应用的 Secret
+	WxSuiteSecretFile    string            `json:"wx_suite_secret_file" yaml:"wx_suite_secret_file"`
+	WxSuitePath          string            `json:"wx_suite_path" yaml:"wx_suite_path"`   // 指令回调路径，接收 suite_ticket 和授权变更
+	WxSuiteStore         string            `json:"wx_suite_store" yaml:"wx_suite_store"` // 保存 suite_ticket 和授权企业永久授权码的目录
+
+	// CryptKeys 全部有效的回调密钥，第一项为当前密钥，由 LoadProfiles 读取 WxKeysFile 后填充
+	CryptKeys []keyring.KeyPair `json:"-" yaml:"-"`
 }
 
+// Route 应用内的一条路由规则，问题命中时由该规则的大模型知识引擎应用回答。
+// 三种条件按指令前缀、关键词、用户部门的顺序匹配，同一种条件按规则顺序匹配。
+type Route struct {
+	Name          string  `json:"name" yaml:"name"`               // 知识库名称，在回答末尾注明
+	LKEAppKey     string  `json:"lke_app_key" yaml:"lke_app_key"` // 大模型知识引擎应用的 BotAppKey
+	LKEAppKeyFile string  `json:"lke_app_key_file" yaml:"lke_app_key_file"`
+	LKESystemRole string  `json:"lke_system_role" yaml:"lke_system_role"` // 大模型知识引擎的角色指令，为空时使用应用设置
+	Prefix        string  `json:"prefix" yaml:"prefix"`                   // 指令前缀，如 #hr，问题以其开头时命中，提问时去掉前缀
+	Pattern       string  `json:"pattern" yaml:"pattern"`                 // 正则表达式，问题匹配时命中
+	Departments   []int64 `json:"departments" yaml:"departments"`         // 部门ID，提问的用户属于其中任一部门时命中
+}
+
+// Init 解析命令行参数并加载配置，配置有误时打印全部错误后退出
 func Init() {
-	// 定义命令行参数
-	flag.StringVar(&Config.WxToken, "wx_token", "", "WeCom App Token")
-	flag.StringVar(&Config.WxEncodingAESKey, "wx_encodingaeskey", "", "WeCom App Encoding AES Key")
-	flag.StringVar(&Config.WxCorpID, "wx_corpid", "", "WeCom Corp ID")
-	flag.StringVar(&Config.WxAppSecret, "wx_appsecret", "", "WeCom App Secret")
-	flag.StringVar(&Config.TencentCloudLKEAppKey, "lke_appkey", "", "TencentCloud LKE App Key")
-
-	// 解析命令行参数
+	defineFlags()
+	flag.StringVar(&configFile, "config", "", "YAML config file, reloaded on SIGHUP or change (env CONFIG_FILE)")
 	flag.Parse()
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+
+	c, err := Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "\nSettings can be provided by command line flags, environment variables or a config file (-config), run with -h to list them.")
+		os.Exit(2)
+	}
+	Set(c)
+}
+
+// Load 重新读取环境变量、配置文件、多应用配置文件和各应用的密钥文件，与启动时的命令行参数合并为新的配置。
+// 优先级：命令行参数 > 环境变量 > 配置文件 > 默认值。
+// 校验全部配置项的格式，有误时返回包含全部错误的 error，每个错误注明出错的配置来自哪里。
+func Load() (*GlobalConfig, error) {
+	c := &GlobalConfig{File: configFile, sources: map[string]string{}, invalid: map[string]bool{}}
+	problems := c.merge()
+	if c.ProfilesFile != "" && len(c.Apps) > 0 {
+		problems = append(problems, fmt.Sprintf("%s: profiles_file cannot be used with apps in config file %s", c.Source("profiles_file"), c.File))
+	}
+	problems = append(problems, c.checkServer()...)
+	problems = append(problems, c.checkRender()...)
+	profiles, profileProblems := c.loadProfiles()
+	problems = append(problems, profileProblems...)
+	if len(problems) > 0 {
+		return nil, JoinProblems(problems)
+	}
+	c.Profiles = profiles
+	return c, nil
+}
+
+// Get 返回当前生效的配置，调用方不能修改
+func Get() *GlobalConfig {
+	return current.Load()
+}
+
+// Set 替换当前生效的配置
+func Set(c *GlobalConfig) {
+	current.Store(c)
+}
 
-	// 如果命令行参数为空，尝试从环境变量获取
-	if Config.WxToken == "" {
-		Config.WxToken = os.Getenv("WX_TOKEN")
+// loadProfiles 返回全部应用配置及全部错误，依次使用配置文件中的 apps、ProfilesFile，
+// 都未配置时由单个应用的配置项组成名为 default 的单个应用
+func (c *GlobalConfig) loadProfiles() ([]Profile, []string) {
+	var profiles []Profile
+	var origins []profileOrigin
+	switch {
+	case len(c.Apps) > 0:
+		profiles = append(profiles, c.Apps...)
+		for i, p := range profiles {
+			origins = append(origins, profileOrigin{source: fmt.Sprintf("config file %s, apps[%d] %q", c.File, i, p.Name), invalid: map[string]bool{}})
+		}
+	case c.ProfilesFile != "":
+		data, err := os.ReadFile(c.ProfilesFile)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("%s: failed to read profiles file: %v", c.Source("profiles_file"), err)}
+		}
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return nil, []string{fmt.Sprintf("profiles file %s: %v", c.ProfilesFile, err)}
+		}
+		for i, p := range profiles {
+			origins = append(origins, profileOrigin{source: fmt.Sprintf("profiles file %s, [%d] %q", c.ProfilesFile, i, p.Name), invalid: map[string]bool{}})
+		}
+	default:
+		profiles = []Profile{{
+			Name:             "default",
+			WxToken:          c.WxToken,
+			WxEncodingAESKey: c.WxEncodingAESKey,
+			WxKeysFile:       c.WxKeysFile,
+			WxCorpID:         c.WxCorpID,
+			WxAppSecret:      c.WxAppSecret,
+			WxAgentID:        c.WxAgentID,
+			LKEAppKey:        c.TencentCloudLKEAppKey,
+			LKESystemRole:    c.LKESystemRole,
+			Replies:          c.Replies,
+			WxSuiteID:        c.WxSuiteID,
+			WxSuiteSecret:    c.WxSuiteSecret,
+			WxSuitePath:      c.WxSuitePath,
+			WxSuiteStore:     c.WxSuiteStore,
+		}}
+		origins = []profileOrigin{{config: c, invalid: c.invalid}}
 	}
-	if Config.WxEncodingAESKey == "" {
-		Config.WxEncodingAESKey = os.Getenv("WX_ENCODING_AES_KEY")
+
+	var problems []string
+	for i := range profiles {
+		p := &profiles[i]
+		if p.WxCorpID == "" && p.WxSuiteID == "" {
+			p.WxCorpID = c.WxCorpID
+		}
+		problems = append(problems, p.resolveSecrets(origins[i])...)
+		keys, keyProblems := loadCryptKeys(p.WxToken, p.WxEncodingAESKey, p.WxKeysFile)
+		for _, msg := range keyProblems {
+			problems = append(problems, origins[i].problem("wx_keys_file", "%s", msg))
+		}
+		p.CryptKeys = keys
+	}
+	problems = append(problems, validateProfiles(profiles, origins)...)
+	for i := range profiles {
+		if profiles[i].LKERenderer == "" {
+			profiles[i].LKERenderer = c.LKERenderer
+		}
+		if profiles[i].LKEChunking == "" {
+			profiles[i].LKEChunking = c.LKEChunking
+		}
+	}
+	return profiles, problems
+}
+
+// secretField 应用配置中的保密字段及保存其值的文件
+type secretField struct {
+	name  string
+	value *string
+	file  string
+}
+
+// resolveSecrets 读取以 _file 结尾的字段指定的密钥文件，并登记全部密钥以便在日志中遮盖，返回全部错误
+func (p *Profile) resolveSecrets(origin profileOrigin) []string {
+	secrets := []secretField{
+		{"wx_token", &p.WxToken, p.WxTokenFile},
+		{"wx_encoding_aes_key", &p.WxEncodingAESKey, p.WxEncodingAESKeyFile},
+		{"wx_app_secret", &p.WxAppSecret, p.WxAppSecretFile},
+		{"lke_app_key", &p.LKEAppKey, p.LKEAppKeyFile},
+		{"wx_suite_secret", &p.WxSuiteSecret, p.WxSuiteSecretFile},
+	}
+	// 路由规则与配置文件中的 apps 共用底层数组，复制后再填充
+	p.Routes = append([]Route(nil), p.Routes...)
+	for i := range p.Routes {
+		r := &p.Routes[i]
+		secrets = append(secrets, secretField{fmt.Sprintf("routes[%d].lke_app_key", i), &r.LKEAppKey, r.LKEAppKeyFile})
+	}
+	var problems []string
+	report := func(field, format string, args ...interface{}) {
+		if msg := origin.problem(field, format, args...); msg != "" {
+			problems = append(problems, msg)
+		}
 	}
-	if Config.WxCorpID == "" {
-		Config.WxCorpID = os.Getenv("WX_CORP_ID")
+	for _, s := range secrets {
+		if s.file != "" {
+			if *s.value != "" {
+				report(s.name, "and %s_file are exclusive", s.name)
+				continue
+			}
+			value, err := readSecretFile(s.file)
+			if err != nil {
+				report(s.name+"_file", "%v", err)
+				origin.invalid[s.name] = true
+				continue
+			}
+			*s.value = value
+		}
+		redact.Register(*s.value)
 	}
-	if Config.WxAppSecret == "" {
-		Config.WxAppSecret = os.Getenv("WX_APP_SECRET")
+	return problems
+}
+
+// loadCryptKeys 返回全部有效的回调密钥，第一项为当前密钥，其后为 keysFile 中配置的其他密钥，
+// 以及密钥文件中的全部错误
+func loadCryptKeys(token, encodingAESKey, keysFile string) ([]keyring.KeyPair, []string) {
+	pairs := []keyring.KeyPair{{Token: token, EncodingAESKey: encodingAESKey}}
+	if keysFile == "" {
+		return pairs, nil
 	}
-	if Config.TencentCloudLKEAppKey == "" {
-		Config.TencentCloudLKEAppKey = os.Getenv("TENCENT_CLOUD_LKE_APP_KEY")
+
+	f, err := os.Open(keysFile)
+	if err != nil {
+		return pairs, []string{fmt.Sprintf("failed to open keys file: %v", err)}
 	}
+	defer f.Close()
 
-	// 验证必要参数是否都已设置
-	if !Config.IsValid() {
-		fmt.Println("Missing required parameters. Please provide them via command line flags or environment variables:")
-		fmt.Println("Environment variables:")
-		fmt.Println("  WX_TOKEN")
-		fmt.Println("  WX_ENCODING_AES_KEY")
-		fmt.Println("  WX_CORP_ID")
-		fmt.Println("  WX_APP_SECRET")
-		fmt.Println("  TENCENT_CLOUD_LKE_APP_KEY")
-		fmt.Println("\nOr command line flags:")
-		flag.PrintDefaults()
-		os.Exit(1)
+	// 每行一组密钥：Token EncodingAESKey，支持 # 注释和空行
+	var problems []string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			problems = append(problems, fmt.Sprintf("%s line %d: want \"Token EncodingAESKey\"", keysFile, lineNo))
+			continue
+		}
+		if err := checkToken(fields[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s line %d: Token %v", keysFile, lineNo, err))
+		}
+		if err := checkEncodingAESKey(fields[1]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s line %d: EncodingAESKey %v", keysFile, lineNo, err))
+		}
+		redact.Register(fields[0], fields[1])
+		pairs = append(pairs, keyring.KeyPair{Token: fields[0], EncodingAESKey: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, fmt.Sprintf("failed to read keys file: %v", err))
+	}
+	return pairs, problems
+}
+
+// LoadFAQ 读取常见问题固定回答，未配置文件时返回空集合。每次调用都会重新读取文件，可用于重载。
+func (c *GlobalConfig) LoadFAQ() (map[string]string, error) {
+	faq := map[string]string{}
+	if c.FAQFile == "" {
+		return faq, nil
+	}
+
+	data, err := os.ReadFile(c.FAQFile)
+	if err != nil {
+		return faq, fmt.Errorf("failed to read faq file: %v", err)
+	}
+	if err := json.Unmarshal(data, &faq); err != nil {
+		return faq, fmt.Errorf("failed to unmarshal faq file: %v", err)
+	}
+	return faq, nil
+}
+
+// LoadReferenceTemplate 读取引用的展示模板，未配置文件时返回空字符串。每次调用都会重新读取文件，可用于重载。
+func (c *GlobalConfig) LoadReferenceTemplate() (string, error) {
+	if c.LKEReferenceTemplate == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(c.LKEReferenceTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to read reference template: %v", err)
 	}
+	return string(data), nil
 }
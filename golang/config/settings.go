@@ -0,0 +1,394 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/play/utils/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// setting 一个配置项，可由命令行参数、环境变量或配置文件设置，优先级依次降低，都未设置时使用默认值
+type setting struct {
+	key     string // 配置文件中的名称
+	flag    string // 命令行参数名称
+	env     string // 环境变量名称
+	def     string // 默认值
+	usage   string
+	restart bool // 修改后需要重启才能生效
+	secret  bool // 保密的配置项，可以从文件读取，日志中会被遮盖
+	field   func(c *GlobalConfig) interface{}
+}
+
+// settings 全部配置项
+var settings = []setting{
+	{key: "listen", flag: "listen", env: "LISTEN_ADDR", def: ":80", restart: true,
+		usage: "Listen address of the callback server (default :443 with TLS)",
+		field: func(c *GlobalConfig) interface{} { return &c.Listen }},
+	{key: "tls_cert_file", flag: "tls_cert_file", env: "TLS_CERT_FILE", restart: true,
+		usage: "TLS certificate file, serves HTTPS if set, reloaded on change",
+		field: func(c *GlobalConfig) interface{} { return &c.TLSCertFile }},
+	{key: "tls_key_file", flag: "tls_key_file", env: "TLS_KEY_FILE", restart: true,
+		usage: "TLS private key file, reloaded on change",
+		field: func(c *GlobalConfig) interface{} { return &c.TLSKeyFile }},
+	{key: "admin_listen", flag: "admin_listen", env: "ADMIN_LISTEN", restart: true,
+		usage: "Listen address of the internal admin server serving /healthz and /reload (default disabled), requires tls_cert_file and admin_client_ca_file",
+		field: func(c *GlobalConfig) interface{} { return &c.AdminListen }},
+	{key: "admin_client_ca_file", flag: "admin_client_ca_file", env: "ADMIN_CLIENT_CA_FILE", restart: true,
+		usage: "CA file verifying client certificates of the admin server (mutual TLS)",
+		field: func(c *GlobalConfig) interface{} { return &c.AdminClientCAFile }},
+	{key: "http_read_timeout", flag: "http_read_timeout", env: "HTTP_READ_TIMEOUT", def: strconv.Itoa(defaultHTTPReadTimeout), restart: true,
+		usage: "Seconds to read a whole request",
+		field: func(c *GlobalConfig) interface{} { return &c.HTTPReadTimeout }},
+	{key: "http_write_timeout", flag: "http_write_timeout", env: "HTTP_WRITE_TIMEOUT", def: strconv.Itoa(defaultHTTPWriteTimeout), restart: true,
+		usage: "Seconds to write a response",
+		field: func(c *GlobalConfig) interface{} { return &c.HTTPWriteTimeout }},
+	{key: "http_idle_timeout", flag: "http_idle_timeout", env: "HTTP_IDLE_TIMEOUT", def: strconv.Itoa(defaultHTTPIdleTimeout), restart: true,
+		usage: "Seconds to keep an idle connection open",
+		field: func(c *GlobalConfig) interface{} { return &c.HTTPIdleTimeout }},
+	{key: "max_body_bytes", flag: "max_body_bytes", env: "MAX_BODY_BYTES", def: strconv.Itoa(defaultMaxBodyBytes), restart: true,
+		usage: "Max bytes of a callback request body",
+		field: func(c *GlobalConfig) interface{} { return &c.MaxBodyBytes }},
+	{key: "wx_token", flag: "wx_token", env: "WX_TOKEN", secret: true,
+		usage: "WeCom App Token",
+		field: func(c *GlobalConfig) interface{} { return &c.WxToken }},
+	{key: "wx_encoding_aes_key", flag: "wx_encodingaeskey", env: "WX_ENCODING_AES_KEY", secret: true,
+		usage: "WeCom App Encoding AES Key",
+		field: func(c *GlobalConfig) interface{} { return &c.WxEncodingAESKey }},
+	{key: "wx_corp_id", flag: "wx_corpid", env: "WX_CORP_ID",
+		usage: "WeCom Corp ID",
+		field: func(c *GlobalConfig) interface{} { return &c.WxCorpID }},
+	{key: "wx_app_secret", flag: "wx_appsecret", env: "WX_APP_SECRET", secret: true,
+		usage: "WeCom App Secret",
+		field: func(c *GlobalConfig) interface{} { return &c.WxAppSecret }},
+	{key: "wx_agent_id", flag: "wx_agentid", env: "WX_AGENT_ID",
+		usage: "WeCom App AgentID, callbacks of other agents are rejected if set",
+		field: func(c *GlobalConfig) interface{} { return &c.WxAgentID }},
+	{key: "wx_keys_file", flag: "wx_keys_file", env: "WX_KEYS_FILE",
+		usage: "File of extra active WeCom Token/EncodingAESKey pairs",
+		field: func(c *GlobalConfig) interface{} { return &c.WxKeysFile }},
+	{key: "lke_app_key", flag: "lke_appkey", env: "TENCENT_CLOUD_LKE_APP_KEY", secret: true,
+		usage: "TencentCloud LKE App Key",
+		field: func(c *GlobalConfig) interface{} { return &c.TencentCloudLKEAppKey }},
+	{key: "lke_system_role", flag: "lke_system_role", env: "LKE_SYSTEM_ROLE",
+		usage: "TencentCloud LKE system role, overrides the role set in the LKE app",
+		field: func(c *GlobalConfig) interface{} { return &c.LKESystemRole }},
+	{key: "passive_reply", flag: "passive_reply", env: "PASSIVE_REPLY",
+		usage: "Reply instant answers in the callback response instead of message/send",
+		field: func(c *GlobalConfig) interface{} { return &c.PassiveReply }},
+	{key: "faq_file", flag: "faq_file", env: "FAQ_FILE",
+		usage: "JSON file of canned FAQ answers",
+		field: func(c *GlobalConfig) interface{} { return &c.FAQFile }},
+	{key: "lke_workers", flag: "lke_workers", env: "LKE_WORKERS", def: strconv.Itoa(defaultLKEWorkers), restart: true,
+		usage: "Max concurrent TencentCloud LKE calls",
+		field: func(c *GlobalConfig) interface{} { return &c.LKEWorkers }},
+	{key: "lke_queue_size", flag: "lke_queue_size", env: "LKE_QUEUE_SIZE", def: strconv.Itoa(defaultLKEQueueSize), restart: true,
+		usage: "Max queued TencentCloud LKE calls",
+		field: func(c *GlobalConfig) interface{} { return &c.LKEQueueSize }},
+	{key: "debounce_seconds", flag: "debounce_seconds", env: "DEBOUNCE_SECONDS", restart: true,
+		usage: "Merge messages a user sends within this many seconds into one question (default 0, disabled)",
+		field: func(c *GlobalConfig) interface{} { return &c.DebounceSeconds }},
+	{key: "shutdown_timeout", flag: "shutdown_timeout", env: "SHUTDOWN_TIMEOUT", def: strconv.Itoa(defaultShutdownTimeout),
+		usage: "Seconds to wait for in-flight and queued answers on shutdown",
+		field: func(c *GlobalConfig) interface{} { return &c.ShutdownTimeout }},
+	{key: "lke_renderer", flag: "lke_renderer", env: "LKE_RENDERER",
+		usage: "How LKE answers are rendered: default, compact, verbose, answer_only",
+		field: func(c *GlobalConfig) interface{} { return &c.LKERenderer }},
+	{key: "lke_thought_mode", flag: "lke_thought_mode", env: "LKE_THOUGHT_MODE",
+		usage: "How reasoning is shown: full, notice, collapsed, hidden (default depends on renderer)",
+		field: func(c *GlobalConfig) interface{} { return &c.LKEThoughtMode }},
+	{key: "lke_chunking", flag: "lke_chunking", env: "LKE_CHUNKING",
+		usage: "How streamed answers are split into messages: paragraph[:N], interval:N, size:N, final (default paragraph)",
+		field: func(c *GlobalConfig) interface{} { return &c.LKEChunking }},
+	{key: "lke_reference_template", flag: "lke_reference_template", env: "LKE_REFERENCE_TEMPLATE",
+		usage: "text/template file defining \"inline\" and \"footnotes\" for references",
+		field: func(c *GlobalConfig) interface{} { return &c.LKEReferenceTemplate }},
+	{key: "lke_link_strip", flag: "lke_link_strip", env: "LKE_LINK_STRIP",
+		usage: "Strip all reference links and keep document names only",
+		field: func(c *GlobalConfig) interface{} { return &c.LKELinkStrip }},
+	{key: "lke_link_rewrite", flag: "lke_link_rewrite", env: "LKE_LINK_REWRITE",
+		usage: "text/template rewriting links of document references, e.g. https://portal.example.com/docs/{{.DocBizID}}",
+		field: func(c *GlobalConfig) interface{} { return &c.LKELinkRewrite }},
+	{key: "lke_link_domains", flag: "lke_link_domains", env: "LKE_LINK_DOMAINS",
+		usage: "Comma separated domains allowed in reference links, others are stripped (default no limit)",
+		field: func(c *GlobalConfig) interface{} { return &c.LKELinkDomains }},
+	{key: "lke_source_auto", flag: "lke_source_auto", env: "LKE_SOURCE_AUTO",
+		usage: "Send cited documents as WeCom file messages after each answer",
+		field: func(c *GlobalConfig) interface{} { return &c.LKESourceAuto }},
+	{key: "tc_secret_id", flag: "tc_secret_id", env: "TENCENT_CLOUD_SECRET_ID", secret: true,
+		usage: "TencentCloud API SecretId for LKE management APIs (optional)",
+		field: func(c *GlobalConfig) interface{} { return &c.TencentCloudSecretID }},
+	{key: "tc_secret_key", flag: "tc_secret_key", env: "TENCENT_CLOUD_SECRET_KEY", secret: true,
+		usage: "TencentCloud API SecretKey for LKE management APIs (optional)",
+		field: func(c *GlobalConfig) interface{} { return &c.TencentCloudSecretKey }},
+	{key: "tc_region", flag: "tc_region", env: "TENCENT_CLOUD_REGION",
+		usage: "TencentCloud region of LKE management APIs (default ap-guangzhou)",
+		field: func(c *GlobalConfig) interface{} { return &c.TencentCloudRegion }},
+	{key: "wx_token_cache", flag: "wx_token_cache", env: "WX_TOKEN_CACHE", secret: true, restart: true,
+		usage: "Access token cache shared by replicas: file:<dir> or redis://[:password@]host:port[/db] (default none)",
+		field: func(c *GlobalConfig) interface{} { return &c.WxTokenCache }},
+	{key: "profiles_file", flag: "profiles_file", env: "PROFILES_FILE",
+		usage: "JSON file of several WeCom apps routed by callback path",
+		field: func(c *GlobalConfig) interface{} { return &c.ProfilesFile }},
+	{key: "wx_suite_id", flag: "wx_suite_id", env: "WX_SUITE_ID",
+		usage: "WeCom third-party suite ID, runs as a service provider instead of a self-built app",
+		field: func(c *GlobalConfig) interface{} { return &c.WxSuiteID }},
+	{key: "wx_suite_secret", flag: "wx_suite_secret", env: "WX_SUITE_SECRET", secret: true,
+		usage: "WeCom third-party suite secret",
+		field: func(c *GlobalConfig) interface{} { return &c.WxSuiteSecret }},
+	{key: "wx_suite_path", flag: "wx_suite_path", env: "WX_SUITE_PATH",
+		usage: "Callback path of suite instructions, e.g. /suite",
+		field: func(c *GlobalConfig) interface{} { return &c.WxSuitePath }},
+	{key: "wx_suite_store", flag: "wx_suite_store", env: "WX_SUITE_STORE",
+		usage: "Directory saving the suite ticket and permanent codes of authorized corps",
+		field: func(c *GlobalConfig) interface{} { return &c.WxSuiteStore }},
+}
+
+// flagValue 命令行参数的原始值，合并配置时才按配置项的类型解析，以区分未设置和设置为零值
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	v.set = true
+	return nil
+}
+
+// IsBoolFlag 布尔类型的参数可以不带值，如 -passive_reply
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// flagValues 全部配置项的命令行参数，key 为配置项名称
+var flagValues = map[string]*flagValue{}
+
+// defineFlags 定义全部配置项的命令行参数
+func defineFlags() {
+	for _, s := range settings {
+		_, isBool := s.field(&GlobalConfig{}).(*bool)
+		v := &flagValue{isBool: isBool}
+		flagValues[s.key] = v
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		if s.def != "" {
+			usage = fmt.Sprintf("%s (env %s, default %s)", s.usage, s.env, s.def)
+		}
+		flag.Var(v, s.flag, usage)
+		if s.secret {
+			file := &flagValue{}
+			flagValues[s.key+"_file"] = file
+			flag.Var(file, s.flag+"_file", fmt.Sprintf("File containing %s (env %s_FILE)", s.usage, s.env))
+		}
+	}
+}
+
+// fileConfig 配置文件的内容，除 apps 和 replies 外的其他项按配置项名称读取
+type fileConfig struct {
+	Apps     []Profile            `yaml:"apps"`
+	Replies  map[string]string    `yaml:"replies"`
+	Settings map[string]yaml.Node `yaml:",inline"`
+}
+
+// merge 按 命令行参数 > 环境变量 > 配置文件 > 默认值 的优先级合并全部配置项，返回全部错误
+func (c *GlobalConfig) merge() []string {
+	var problems []string
+	var file fileConfig
+	if c.File != "" {
+		data, err := os.ReadFile(c.File)
+		if err != nil {
+			return []string{fmt.Sprintf("failed to read config file: %v", err)}
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return []string{fmt.Sprintf("failed to parse config file %s: %v", c.File, err)}
+		}
+		known := map[string]bool{}
+		for _, s := range settings {
+			known[s.key] = true
+			if s.secret {
+				known[s.key+"_file"] = true
+			}
+		}
+		for key := range file.Settings {
+			if !known[key] {
+				problems = append(problems, fmt.Sprintf("config file %s: unknown setting %q", c.File, key))
+			}
+		}
+		c.Apps = file.Apps
+		c.Replies = file.Replies
+	}
+
+	for _, s := range settings {
+		source, err := s.resolve(c, file.Settings)
+		if source == "" {
+			if s.def != "" {
+				setValue(s.field(c), s.def)
+				c.sources[s.key] = "default"
+			}
+			continue
+		}
+		c.sources[s.key] = source
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s %v", source, s.key, err))
+			c.invalid[s.key] = true
+		}
+		if s.secret {
+			redact.Register(formatValue(s.field(c)))
+		}
+	}
+	return problems
+}
+
+// resolve 按优先级设置配置项的值并返回其来源，都未设置时返回空字符串。
+// 保密的配置项在每一层都可以改为指定文件，从文件读取，如 -wx_appsecret_file、WX_APP_SECRET_FILE、wx_app_secret_file。
+func (s setting) resolve(c *GlobalConfig, nodes map[string]yaml.Node) (string, error) {
+	ptr := s.field(c)
+	if v := flagValues[s.key]; v != nil && v.set {
+		return "flag -" + s.flag, setValue(ptr, v.value)
+	}
+	if v := flagValues[s.key+"_file"]; v != nil && v.set {
+		return "flag -" + s.flag + "_file", setFromFile(ptr, v.value)
+	}
+	if value := os.Getenv(s.env); value != "" {
+		return "env " + s.env, setValue(ptr, value)
+	}
+	if path := os.Getenv(s.env + "_FILE"); s.secret && path != "" {
+		return "env " + s.env + "_FILE", setFromFile(ptr, path)
+	}
+	if node, ok := nodes[s.key]; ok {
+		return fmt.Sprintf("config file %s (%s)", c.File, s.key), node.Decode(ptr)
+	}
+	if node, ok := nodes[s.key+"_file"]; s.secret && ok {
+		source := fmt.Sprintf("config file %s (%s_file)", c.File, s.key)
+		var path string
+		if err := node.Decode(&path); err != nil {
+			return source, err
+		}
+		return source, setFromFile(ptr, path)
+	}
+	return "", nil
+}
+
+// setFromFile 读取文件内容作为配置项的值，去掉首尾空白
+func setFromFile(ptr interface{}, path string) error {
+	value, err := readSecretFile(path)
+	if err != nil {
+		return err
+	}
+	return setValue(ptr, value)
+}
+
+// readSecretFile 读取保存密钥的文件，去掉首尾空白，如 Docker/Kubernetes 挂载的 secret 文件
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// setValue 按配置项的类型解析 value
+func setValue(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+		*p = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// formatValue 返回配置项的值，用于比较配置是否修改
+func formatValue(ptr interface{}) string {
+	switch p := ptr.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *int64:
+		return strconv.FormatInt(*p, 10)
+	}
+	return ""
+}
+
+// Source 返回配置项的来源，如 flag -wx_token、env WX_TOKEN，未设置时返回空字符串
+func (c *GlobalConfig) Source(key string) string {
+	return c.sources[key]
+}
+
+// RetainRestartSettings 保留需要重启才能生效的配置项的原值，返回其中被修改的配置项名称
+func (c *GlobalConfig) RetainRestartSettings(previous *GlobalConfig) []string {
+	var changed []string
+	for _, s := range settings {
+		if !s.restart {
+			continue
+		}
+		ptr, old := s.field(c), s.field(previous)
+		if formatValue(ptr) == formatValue(old) {
+			continue
+		}
+		changed = append(changed, s.key)
+		setValue(ptr, formatValue(old))
+		c.sources[s.key] = previous.sources[s.key]
+	}
+	return changed
+}
+
+// findSetting 按名称返回配置项
+func findSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// describe 返回配置项的全部设置方式，用于提示缺少的配置项
+func describe(key string) string {
+	s, ok := findSetting(key)
+	switch {
+	case !ok:
+		return key
+	case s.secret:
+		return fmt.Sprintf("%s (flag -%s or -%s_file, env %s or %s_FILE)", s.key, s.flag, s.flag, s.env, s.env)
+	default:
+		return fmt.Sprintf("%s (flag -%s, env %s)", s.key, s.flag, s.env)
+	}
+}
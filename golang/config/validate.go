@@ -0,0 +1,258 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"example.com/play/logic/render"
+)
+
+var (
+	// corpIDPattern 企业ID：ww 或 wx 开头，后接 16 位十六进制字符
+	corpIDPattern = regexp.MustCompile(`^w[wx][0-9a-f]{16}$`)
+	// suiteIDPattern 第三方应用的 SuiteID：ww 或 tj 开头，后接 16 位十六进制字符
+	suiteIDPattern = regexp.MustCompile(`^(ww|tj)[0-9a-f]{16}$`)
+	// tokenPattern 接收消息配置中的 Token：3 到 32 位英文或数字
+	tokenPattern = regexp.MustCompile(`^[A-Za-z0-9]{3,32}$`)
+)
+
+// profileOrigin 应用配置的来源，用于在错误中注明出错的配置来自哪里
+type profileOrigin struct {
+	// source 多应用配置中的应用，如 config file a.yaml, apps[0] "hr"，为空时应用由单个应用的配置项组成
+	source string
+	// config 单个应用时按字段名称查找对应配置项的来源
+	config *GlobalConfig
+	// invalid 读取出错的字段，校验时不再重复报错
+	invalid map[string]bool
+}
+
+// problem 返回注明来源的错误，字段读取已经出错时返回空字符串，避免重复报错
+func (o profileOrigin) problem(field, format string, args ...interface{}) string {
+	if o.invalid[field] {
+		return ""
+	}
+	msg := fmt.Sprintf(format, args...)
+	if o.source != "" {
+		return fmt.Sprintf("%s: %s %s", o.source, field, msg)
+	}
+	if source := o.config.Source(field); source != "" {
+		return fmt.Sprintf("%s: %s %s", source, field, msg)
+	}
+	return fmt.Sprintf("%s %s", describe(field), msg)
+}
+
+// validateProfiles 校验应用配置并返回全部错误：必填字段和格式，名称和回调路径不能重复，
+// 多个应用时必须配置应用ID用于校验回调消息
+func validateProfiles(profiles []Profile, origins []profileOrigin) []string {
+	if len(profiles) == 0 {
+		return []string{"no profile configured"}
+	}
+	type agentKey struct {
+		corpID  string
+		agentID int64
+	}
+	var problems []string
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	agents := map[agentKey]bool{}
+	for i, p := range profiles {
+		origin := origins[i]
+		report := func(field, format string, args ...interface{}) {
+			if msg := origin.problem(field, format, args...); msg != "" {
+				problems = append(problems, msg)
+			}
+		}
+
+		if p.Name == "" {
+			report("name", "is missing")
+		} else if names[p.Name] {
+			report("name", "%q is duplicated", p.Name)
+		}
+		names[p.Name] = true
+		if p.WxToken == "" {
+			report("wx_token", "is missing")
+		} else if err := checkToken(p.WxToken); err != nil {
+			report("wx_token", "%v", err)
+		}
+		if p.WxEncodingAESKey == "" {
+			report("wx_encoding_aes_key", "is missing")
+		} else if err := checkEncodingAESKey(p.WxEncodingAESKey); err != nil {
+			report("wx_encoding_aes_key", "%v", err)
+		}
+		if p.LKEAppKey == "" {
+			report("lke_app_key", "is missing")
+		} else if strings.TrimSpace(p.LKEAppKey) != p.LKEAppKey || strings.ContainsAny(p.LKEAppKey, " \t\r\n") {
+			report("lke_app_key", "contains spaces")
+		}
+		if p.WxAgentID < 0 {
+			report("wx_agent_id", "must be a positive number, got %d", p.WxAgentID)
+		}
+		if p.LKERenderer != "" {
+			if _, err := render.New(p.LKERenderer, render.Options{}); err != nil {
+				report("lke_renderer", "%v", err)
+			}
+		}
+		if p.LKEChunking != "" {
+			if _, err := render.NewChunker(p.LKEChunking); err != nil {
+				report("lke_chunking", "%v", err)
+			}
+		}
+
+		if p.WxSuiteID != "" {
+			if !suiteIDPattern.MatchString(p.WxSuiteID) {
+				report("wx_suite_id", "%q does not look like a SuiteID (ww or tj followed by 16 hex digits)", p.WxSuiteID)
+			}
+			if p.WxSuiteSecret == "" {
+				report("wx_suite_secret", "is missing, required by a suite")
+			}
+			if p.WxSuiteStore == "" {
+				report("wx_suite_store", "is missing, required by a suite")
+			}
+			if !strings.HasPrefix(p.WxSuitePath, "/") {
+				report("wx_suite_path", "%q must start with /, required by a suite", p.WxSuitePath)
+			} else if p.WxSuitePath == p.Path || paths[p.WxSuitePath] {
+				report("wx_suite_path", "%q is duplicated", p.WxSuitePath)
+			}
+			paths[p.WxSuitePath] = true
+		} else {
+			if p.WxCorpID == "" {
+				report("wx_corp_id", "is missing")
+			} else if !corpIDPattern.MatchString(p.WxCorpID) {
+				report("wx_corp_id", "%q does not look like a corp ID (ww or wx followed by 16 hex digits)", p.WxCorpID)
+			}
+			if p.WxAppSecret == "" {
+				report("wx_app_secret", "is missing")
+			}
+		}
+		for _, msg := range validateRoutes(p.Routes) {
+			report("routes", "%s", msg)
+		}
+
+		if len(profiles) == 1 {
+			continue
+		}
+		if !strings.HasPrefix(p.Path, "/") || p.Path == "/healthz" {
+			report("path", "%q must start with / and must not be /healthz with several profiles", p.Path)
+		} else if paths[p.Path] {
+			report("path", "%q is duplicated", p.Path)
+		}
+		paths[p.Path] = true
+		if p.WxSuiteID != "" {
+			// 授权企业中的应用ID在安装时分配，由授权信息校验
+			continue
+		}
+		if p.WxAgentID == 0 {
+			report("wx_agent_id", "is required with several profiles")
+			continue
+		}
+		key := agentKey{corpID: p.WxCorpID, agentID: p.WxAgentID}
+		if agents[key] {
+			report("wx_agent_id", "%d of corp %s is duplicated", p.WxAgentID, p.WxCorpID)
+		}
+		agents[key] = true
+	}
+	return problems
+}
+
+// validateRoutes 校验路由规则：名称和 lke_app_key 必填，且至少配置一种匹配条件
+func validateRoutes(routes []Route) []string {
+	var problems []string
+	names := map[string]bool{}
+	for i, r := range routes {
+		if r.Name == "" || r.LKEAppKey == "" {
+			problems = append(problems, fmt.Sprintf("[%d]: name and lke_app_key are required", i))
+			continue
+		}
+		if names[r.Name] {
+			problems = append(problems, fmt.Sprintf("[%d]: duplicate name %q", i, r.Name))
+		}
+		names[r.Name] = true
+		if r.Prefix == "" && r.Pattern == "" && len(r.Departments) == 0 {
+			problems = append(problems, fmt.Sprintf("%q: one of prefix, pattern and departments is required", r.Name))
+		}
+		if strings.ContainsAny(r.Prefix, " \t\n") {
+			problems = append(problems, fmt.Sprintf("%q: prefix %q contains spaces", r.Name, r.Prefix))
+		}
+		if r.Pattern != "" {
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				problems = append(problems, fmt.Sprintf("%q: invalid pattern: %v", r.Name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkToken 校验接收消息配置中的 Token，错误中不包含 Token 本身
+func checkToken(token string) error {
+	if !tokenPattern.MatchString(token) {
+		return fmt.Errorf("must be 3 to 32 letters or digits, got %d characters", len(token))
+	}
+	return nil
+}
+
+// checkEncodingAESKey 校验接收消息配置中的 EncodingAESKey：43 位 base64 字符，补上 = 后解码为 32 字节的 AES 密钥。
+// 错误中不包含密钥本身。
+func checkEncodingAESKey(key string) error {
+	if len(key) != 43 {
+		return fmt.Errorf("must be 43 characters, got %d", len(key))
+	}
+	aesKey, err := base64.StdEncoding.DecodeString(key + "=")
+	if err != nil || len(aesKey) != 32 {
+		return fmt.Errorf("must be base64 (A-Z, a-z, 0-9, + and /)")
+	}
+	return nil
+}
+
+// checkServer 校验 TLS、管理服务和超时配置：证书和私钥需同时配置，双向 TLS 需要管理服务和证书
+func (c *GlobalConfig) checkServer() []string {
+	var problems []string
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		set, unset := "tls_cert_file", "tls_key_file"
+		if c.TLSCertFile == "" {
+			set, unset = unset, set
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s is set but %s is missing", c.Source(set), set, describe(unset)))
+	}
+	if c.AdminClientCAFile != "" && (c.AdminListen == "" || c.TLSCertFile == "") {
+		problems = append(problems, fmt.Sprintf("%s: admin_client_ca_file requires admin_listen and tls_cert_file", c.Source("admin_client_ca_file")))
+	}
+	// 管理服务的 /reload 没有其他认证，只允许通过双向 TLS 访问
+	if c.AdminListen != "" && (c.AdminClientCAFile == "" || c.TLSCertFile == "") {
+		problems = append(problems, fmt.Sprintf("%s: admin_listen requires tls_cert_file and admin_client_ca_file (mutual TLS)", c.Source("admin_listen")))
+	}
+	for _, key := range []string{"http_read_timeout", "http_write_timeout", "http_idle_timeout", "max_body_bytes"} {
+		if s, _ := findSetting(key); !c.invalid[key] && *s.field(c).(*int) <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: %s must be positive", c.Source(key), key))
+		}
+	}
+	return problems
+}
+
+// checkRender 校验全局的渲染方式、思考过程展示方式和切分策略，各应用未单独配置时使用
+func (c *GlobalConfig) checkRender() []string {
+	var problems []string
+	report := func(key string, err error) {
+		if !c.invalid[key] {
+			problems = append(problems, fmt.Sprintf("%s: %s %v", c.Source(key), key, err))
+		}
+	}
+	if _, err := render.New(c.LKERenderer, render.Options{}); err != nil {
+		report("lke_renderer", err)
+	}
+	if c.LKEThoughtMode != "" {
+		if _, err := render.ParseThoughtMode(c.LKEThoughtMode); err != nil {
+			report("lke_thought_mode", err)
+		}
+	}
+	if _, err := render.NewChunker(c.LKEChunking); err != nil {
+		report("lke_chunking", err)
+	}
+	return problems
+}
+
+// JoinProblems 将全部错误合并为一个，每行一个，启动和重载时的配置错误都使用该格式
+func JoinProblems(problems []string) error {
+	return fmt.Errorf("invalid config, %d problem(s):\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+}
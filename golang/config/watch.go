@@ -0,0 +1,36 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Watch 每隔 interval 检查一次配置文件，修改时间或大小变化时发出通知。
+// 未使用配置文件时返回 nil，从 nil 通道接收会一直阻塞。
+func Watch(interval time.Duration) <-chan struct{} {
+	if configFile == "" {
+		return nil
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		last, _ := os.Stat(configFile)
+		for range time.Tick(interval) {
+			info, err := os.Stat(configFile)
+			if err != nil {
+				// 编辑器保存时可能短暂删除文件，下次检查时再比较
+				log.Printf("Stat config file failed, err: %v", err)
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
@@ -2,4 +2,7 @@ module example.com/play
 
 go 1.21.1
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,98 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/play/logic/render"
+	wecomEntity "example.com/play/repo/wecom/entity"
+)
+
+// command 以“/”开头的内置指令
+type command struct {
+	name   string
+	usage  string
+	desc   string
+	handle func(msg *wecomEntity.WxBizMsg, args []string) string
+}
+
+var commands []*command
+
+func init() {
+	registerCommand("/help", "/help", "查看帮助", func(msg *wecomEntity.WxBizMsg, args []string) string {
+		return helpText()
+	})
+	registerCommand("/thought", "/thought [full|notice|collapsed|hidden|default]", "设置思考过程的展示方式", handleThoughtCommand)
+	registerCommand("/rate", "/rate up|down [原因]", "评价最近一次回答", handleRateCommand)
+	registerCommand("/new", "/new", "开始新的对话", handleNewCommand)
+	registerCommand("/source", "/source [编号]", "查看或获取最近一次回答引用的文档原文", handleSourceCommand)
+}
+
+func registerCommand(name, usage, desc string, handle func(msg *wecomEntity.WxBizMsg, args []string) string) {
+	commands = append(commands, &command{name: name, usage: usage, desc: desc, handle: handle})
+}
+
+// runCommand 执行内置指令，不是已知指令时返回 false
+func runCommand(msg *wecomEntity.WxBizMsg) (string, bool) {
+	fields := strings.Fields(msg.Content)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", false
+	}
+	for _, cmd := range commands {
+		if strings.EqualFold(cmd.name, fields[0]) {
+			return cmd.handle(msg, fields[1:]), true
+		}
+	}
+	return "", false
+}
+
+func helpText() string {
+	var b strings.Builder
+	b.WriteString("直接输入问题即可向智能助手提问。\n\n可用指令：")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "\n%s %s", cmd.usage, cmd.desc)
+	}
+	return b.String()
+}
+
+func handleThoughtCommand(msg *wecomEntity.WxBizMsg, args []string) string {
+	if len(args) == 0 {
+		mode, ok := userThoughtMode(msg.FromUserName)
+		if !ok {
+			return fmt.Sprintf("当前思考过程展示方式：%s（应用默认）\n\n%s", thoughtModeText(appThoughtMode()), thoughtModeUsage)
+		}
+		return fmt.Sprintf("当前思考过程展示方式：%s\n\n%s", thoughtModeText(mode), thoughtModeUsage)
+	}
+	if args[0] == "default" {
+		setUserThoughtMode(msg.FromUserName, "")
+		return fmt.Sprintf("已恢复应用默认的思考过程展示方式：%s", thoughtModeText(appThoughtMode()))
+	}
+	mode, err := render.ParseThoughtMode(args[0])
+	if err != nil {
+		return thoughtModeUsage
+	}
+	setUserThoughtMode(msg.FromUserName, mode)
+	return fmt.Sprintf("思考过程展示方式已设置为：%s", thoughtModeText(mode))
+}
+
+const thoughtModeUsage = "用法：/thought [full|notice|collapsed|hidden|default]\n" +
+	"full 实时输出完整思考过程\n" +
+	"notice 只提示思考耗时\n" +
+	"collapsed 回答结束后将思考过程合并为一条消息发送\n" +
+	"hidden 不展示思考过程\n" +
+	"default 恢复应用默认设置"
+
+func thoughtModeText(mode render.ThoughtMode) string {
+	switch mode {
+	case render.ThoughtFull:
+		return "实时输出完整思考过程"
+	case render.ThoughtNotice:
+		return "只提示思考耗时"
+	case render.ThoughtCollapsed:
+		return "回答结束后合并发送"
+	case render.ThoughtHidden:
+		return "不展示"
+	default:
+		return "跟随渲染方式"
+	}
+}
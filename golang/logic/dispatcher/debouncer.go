@@ -0,0 +1,75 @@
+package dispatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer 将同一 Key 在窗口期内连续到达的数据合并为一批交给 flush 处理。
+// 每收到一条数据窗口期重新计时，同一 Key 的批次按到达顺序依次 flush。
+type Debouncer[T any] struct {
+	window time.Duration
+	flush  func(key string, items []T)
+
+	mu      sync.Mutex
+	batches map[string]*batch[T]
+}
+
+type batch[T any] struct {
+	items []T
+	timer *time.Timer
+}
+
+// NewDebouncer 创建合并器，window 为合并窗口期
+func NewDebouncer[T any](window time.Duration, flush func(key string, items []T)) *Debouncer[T] {
+	return &Debouncer[T]{
+		window:  window,
+		flush:   flush,
+		batches: make(map[string]*batch[T]),
+	}
+}
+
+// Add 添加一条数据，窗口期内没有新数据到达时触发 flush
+func (d *Debouncer[T]) Add(key string, item T) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, ok := d.batches[key]
+	if ok {
+		// 计时器已触发但尚未取走批次时 Stop 返回 false，此时追加的数据仍会随该批次 flush
+		b.timer.Stop()
+		b.items = append(b.items, item)
+		b.timer.Reset(d.window)
+		return
+	}
+	b = &batch[T]{items: []T{item}}
+	b.timer = time.AfterFunc(d.window, func() { d.fire(key, b) })
+	d.batches[key] = b
+}
+
+// Flush 立即处理全部未到期的批次
+func (d *Debouncer[T]) Flush() {
+	d.mu.Lock()
+	batches := d.batches
+	d.batches = make(map[string]*batch[T])
+	d.mu.Unlock()
+
+	for key, b := range batches {
+		b.timer.Stop()
+		d.flush(key, b.items)
+	}
+}
+
+func (d *Debouncer[T]) fire(key string, b *batch[T]) {
+	d.mu.Lock()
+	if d.batches[key] != b {
+		// 已被 Flush 取走
+		d.mu.Unlock()
+		return
+	}
+	delete(d.batches, key)
+	items := b.items
+	d.mu.Unlock()
+
+	d.flush(key, items)
+}
@@ -0,0 +1,220 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	// ErrQueueFull 排队任务数已达上限
+	ErrQueueFull = errors.New("dispatcher queue is full")
+	// ErrClosed 分发器已关闭，不再接受新任务
+	ErrClosed = errors.New("dispatcher is closed")
+)
+
+// Task 待处理的任务
+type Task struct {
+	Key string // 串行维度（如用户ID），同一 Key 同时最多处理一个任务
+	// Run 处理任务，ctx 在分发器关闭且超过等待期限时取消
+	Run func(ctx context.Context)
+	// Drop 可选，分发器关闭后超过等待期限任务仍未开始处理则调用
+	Drop func()
+}
+
+// Queued 任务提交时的排队情况，零值表示立即开始处理
+type Queued struct {
+	// SameKey 同一 Key 还有尚未处理完的任务，该任务在它们之后处理
+	SameKey bool
+	// Position 同一 Key 没有其他任务、但全部 worker 都在忙时的排队位置，
+	// 之前排队的任务同一 Key 只计一次；0 表示不需要等待空闲的 worker
+	Position int
+}
+
+// Dispatcher 固定数量的 worker 处理有界队列中的任务，同一 Key 的任务按提交顺序逐个处理
+type Dispatcher struct {
+	workers   int
+	queueSize int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	closed  bool
+	ready   []*Task         // 已分配到 worker、即将开始处理的任务
+	waiting []*Task         // 排队中的任务
+	running map[string]bool // 已分配或正在处理任务的 Key
+}
+
+// New 创建并启动任务分发器，workers 为最大并发数，queueSize 为最多排队任务数
+func New(workers, queueSize int) *Dispatcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	d := &Dispatcher{
+		workers:   workers,
+		queueSize: queueSize,
+		running:   make(map[string]bool),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.cond = sync.NewCond(&d.mu)
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Submit 提交任务，返回任务的排队情况，见 Queued。
+// 需要排队但队列已满时返回 ErrQueueFull，分发器已关闭时返回 ErrClosed。
+func (d *Dispatcher) Submit(t *Task) (Queued, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return Queued{}, ErrClosed
+	}
+	// 同一 Key 的任务之间不会相互超越，其他 Key 排队中的任务只要 Key 空闲就可能先开始
+	keys := map[string]bool{}
+	for _, w := range d.waiting {
+		keys[w.Key] = true
+	}
+	sameKey := d.running[t.Key] || keys[t.Key]
+	if !sameKey && len(d.running) < d.workers {
+		d.start(t)
+		return Queued{}, nil
+	}
+	if len(d.waiting) >= d.queueSize {
+		return Queued{}, ErrQueueFull
+	}
+	d.waiting = append(d.waiting, t)
+	if sameKey {
+		return Queued{SameKey: true}, nil
+	}
+	return Queued{Position: len(keys) + 1}, nil
+}
+
+// Stats 返回正在处理和排队中的任务数
+func (d *Dispatcher) Stats() (running int, waiting int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.running), len(d.waiting)
+}
+
+// Close 停止接受新任务，已开始和排队中的任务继续处理，见 Wait
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	d.cond.Broadcast()
+	log.Printf("Dispatcher closed, running tasks: %d, waiting tasks: %d", len(d.running), len(d.waiting))
+}
+
+// Wait 等待已开始和排队中的任务全部处理完毕；ctx 结束时丢弃仍未开始的任务（调用其 Drop），
+// 取消仍在处理的任务，并等待它们退出。需先调用 Close。
+func (d *Dispatcher) Wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Dispatcher drained")
+	case <-ctx.Done():
+		dropped := d.dropPending()
+		running, _ := d.Stats()
+		log.Printf("Dispatcher drain deadline exceeded, dropped waiting tasks: %d, cancel running tasks: %d", len(dropped), running)
+		d.cancel()
+		for _, t := range dropped {
+			if t.Drop != nil {
+				t.Drop()
+			}
+		}
+		<-done
+	}
+}
+
+// dropPending 取出全部尚未开始处理的任务，之后 worker 处理完当前任务即退出
+func (d *Dispatcher) dropPending() []*Task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := append(d.ready, d.waiting...)
+	for _, t := range d.ready {
+		delete(d.running, t.Key)
+	}
+	d.ready = nil
+	d.waiting = nil
+	d.cond.Broadcast()
+	return dropped
+}
+
+// Shutdown 关闭分发器并等待已开始和排队中的任务处理完毕，超过 ctx 期限则丢弃或取消
+func (d *Dispatcher) Shutdown(ctx context.Context) {
+	d.Close()
+	d.Wait(ctx)
+}
+
+// start 将任务分配给 worker，调用方需持有锁
+func (d *Dispatcher) start(t *Task) {
+	d.running[t.Key] = true
+	d.ready = append(d.ready, t)
+	d.cond.Signal()
+}
+
+// schedule 按提交顺序把所属 Key 空闲的排队任务分配给空闲 worker，调用方需持有锁
+func (d *Dispatcher) schedule() {
+	remaining := d.waiting[:0]
+	for _, t := range d.waiting {
+		if !d.running[t.Key] && len(d.running) < d.workers {
+			d.start(t)
+			continue
+		}
+		remaining = append(remaining, t)
+	}
+	d.waiting = remaining
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		for len(d.ready) == 0 && (!d.closed || len(d.waiting) > 0) {
+			d.cond.Wait()
+		}
+		if len(d.ready) == 0 {
+			// 已关闭且没有排队中的任务
+			d.mu.Unlock()
+			return
+		}
+		t := d.ready[0]
+		d.ready = d.ready[1:]
+		d.mu.Unlock()
+
+		d.run(t)
+
+		d.mu.Lock()
+		delete(d.running, t.Key)
+		d.schedule()
+		d.mu.Unlock()
+	}
+}
+
+func (d *Dispatcher) run(t *Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Dispatcher task panic, key: %s, err: %v", t.Key, r)
+		}
+	}()
+	t.Run(d.ctx)
+}
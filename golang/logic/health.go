@@ -0,0 +1,55 @@
+package logic
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthStatus 健康检查的响应体，全部应用的 access token 都有效时为健康
+type healthStatus struct {
+	Healthy  bool            `json:"healthy"`
+	Profiles []profileHealth `json:"profiles"`
+}
+
+// profileHealth 单个应用的 access token 状态
+type profileHealth struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	TokenLastSuccess    time.Time `json:"token_last_success,omitempty"`
+	TokenExpiresAt      time.Time `json:"token_expires_at,omitempty"`
+	TokenLastError      string    `json:"token_last_error,omitempty"`
+	TokenLastErrorAt    time.Time `json:"token_last_error_at,omitempty"`
+	ConsecutiveFailures int       `json:"token_consecutive_failures"`
+}
+
+// HealthHandler 健康检查接口，全部应用的 access token 都有效时返回 200，否则返回 503
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	resp := healthStatus{Healthy: true}
+	if set := profiles.Load(); set != nil {
+		for _, p := range set.list {
+			status := p.tokens.Status()
+			item := profileHealth{
+				Name:                p.Name,
+				Healthy:             status.Healthy(),
+				TokenLastSuccess:    status.LastSuccess,
+				TokenExpiresAt:      status.ExpiresAt,
+				TokenLastErrorAt:    status.LastErrorAt,
+				ConsecutiveFailures: status.ConsecutiveFailures,
+			}
+			if status.LastError != nil {
+				item.TokenLastError = status.LastError.Error()
+			}
+			resp.Healthy = resp.Healthy && item.Healthy
+			resp.Profiles = append(resp.Profiles, item)
+		}
+	}
+	if len(resp.Profiles) == 0 {
+		resp.Healthy = false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
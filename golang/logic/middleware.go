@@ -0,0 +1,203 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"example.com/play/logic/dispatcher"
+	wecomEntity "example.com/play/repo/wecom/entity"
+	"example.com/play/repo/wecom/keyring"
+)
+
+// MessageContext 一条已解密的用户消息在中间件链中的上下文
+type MessageContext struct {
+	Msg    *wecomEntity.WxBizMsg
+	Params *wecomEntity.WxBizURLParam
+	Key    keyring.KeyPair // 解密该消息命中的密钥，被动回复时使用同一密钥加密
+
+	profile *appProfile // 接收该消息的企业微信应用
+	w       http.ResponseWriter
+	replied bool
+	values  map[string]interface{}
+}
+
+// Reply 立即回复一条文本消息，开启被动回复时直接写入回调响应。每条消息只能回复一次。
+func (c *MessageContext) Reply(content string) {
+	if c.replied {
+		log.Printf("Reply ignored, message already replied, msgId: %d", c.Msg.MsgId)
+		return
+	}
+	c.replied = true
+	replyText(c.w, c.Params, c.profile.keys, c.Key, c.Msg, content)
+}
+
+// Replied 当前消息是否已经回复
+func (c *MessageContext) Replied() bool {
+	return c.replied
+}
+
+// Set 保存中间件之间传递的数据
+func (c *MessageContext) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+// Get 读取中间件之间传递的数据
+func (c *MessageContext) Get(key string) (interface{}, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// MessageHandler 入站消息处理函数
+type MessageHandler func(c *MessageContext)
+
+// Middleware 入站消息中间件，调用 next 继续处理，不调用则中断后续处理
+type Middleware func(next MessageHandler) MessageHandler
+
+// ReplyFilter 出站回复过滤器，返回改写后的回复内容，返回空字符串则不发送该条回复
+type ReplyFilter func(msg *wecomEntity.WxBizMsg, reply string) string
+
+var (
+	pipelineMutex sync.RWMutex
+	middlewares   []Middleware
+	replyFilters  []ReplyFilter
+)
+
+// Use 注册自定义入站中间件，按注册顺序执行。
+// 完整处理顺序为：LoggingMiddleware -> 自定义中间件 -> TextOnlyMiddleware -> InstantAnswerMiddleware -> 调用大模型知识引擎
+func Use(mw ...Middleware) {
+	pipelineMutex.Lock()
+	defer pipelineMutex.Unlock()
+	middlewares = append(middlewares, mw...)
+}
+
+// UseReplyFilter 注册出站回复过滤器，按注册顺序执行，对被动回复和主动发送的消息均生效
+func UseReplyFilter(f ...ReplyFilter) {
+	pipelineMutex.Lock()
+	defer pipelineMutex.Unlock()
+	replyFilters = append(replyFilters, f...)
+}
+
+// LoggingMiddleware 默认中间件：打印收到的消息
+func LoggingMiddleware(next MessageHandler) MessageHandler {
+	return func(c *MessageContext) {
+		log.Printf("ParseMsg process success, msg: %+v", *c.Msg)
+		next(c)
+	}
+}
+
+// TextOnlyMiddleware 默认中间件：目前仅支持文本消息对接大模型知识引擎，其他消息类型返回提示
+func TextOnlyMiddleware(next MessageHandler) MessageHandler {
+	return func(c *MessageContext) {
+		if c.Msg.MsgType != wecomEntity.MsgTypeText {
+			c.Reply(replyFor(c.Msg, replyUnsupportedMsgType))
+			return
+		}
+		next(c)
+	}
+}
+
+// InstantAnswerMiddleware 默认中间件：指令和常见问题可以立即回答，无需调用大模型知识引擎
+func InstantAnswerMiddleware(next MessageHandler) MessageHandler {
+	return func(c *MessageContext) {
+		if answer, ok := instantAnswer(c.Msg); ok {
+			c.Reply(answer)
+			return
+		}
+		next(c)
+	}
+}
+
+// callLKEHandler 中间件链的终点：将用户的消息提交到任务分发器排队调用腾讯云大模型知识引擎，
+// 流式回答通过发送应用消息接口主动发送。开启消息合并时先等待合并窗口期结束再提交。
+func callLKEHandler(c *MessageContext) {
+	if lkeDebouncer != nil {
+		lkeDebouncer.Add(userKey(c.Msg), c.Msg)
+		return
+	}
+	submitLKETask(c.Msg, c.Reply)
+}
+
+// submitLKETask 提交调用大模型知识引擎的任务，需要排队时通过 reply 告知用户：等待自己之前的问题时提示稍候，
+// 全部 worker 都在忙时告知排队位置；队列已满时回复繁忙提示
+func submitLKETask(msg *wecomEntity.WxBizMsg, reply func(content string)) {
+	queued, err := lkeDispatcher.Submit(&dispatcher.Task{
+		Key:  userKey(msg),
+		Run:  func(ctx context.Context) { CallTencentLKEApp(ctx, msg) },
+		Drop: func() { sendText(msg, replyFor(msg, replyShuttingDown)) },
+	})
+	if err != nil {
+		log.Printf("Submit LKE task failed, msgId: %d, err: %v", msg.MsgId, err)
+		if errors.Is(err, dispatcher.ErrClosed) {
+			reply(replyFor(msg, replyShuttingDown))
+		} else {
+			reply(replyFor(msg, replyBusy))
+		}
+		return
+	}
+	switch {
+	case queued.SameKey:
+		// 只是在等待该用户自己之前的问题，不是服务繁忙
+		log.Printf("LKE task queued after previous question of the user, msgId: %d", msg.MsgId)
+		reply(replyFor(msg, replyPreviousPending))
+	case queued.Position > 0:
+		log.Printf("LKE task queued, msgId: %d, position: %d", msg.MsgId, queued.Position)
+		reply(replyFor(msg, replyQueued, "{position}", strconv.Itoa(queued.Position)))
+	}
+}
+
+// submitMergedMessages 将合并窗口期内同一用户的多条消息合并为一个问题后提交，
+// 此时回调请求已经结束，排队和繁忙提示只能主动发送
+func submitMergedMessages(key string, msgs []*wecomEntity.WxBizMsg) {
+	merged := *msgs[len(msgs)-1]
+	if len(msgs) > 1 {
+		contents := make([]string, 0, len(msgs))
+		for _, m := range msgs {
+			contents = append(contents, m.Content)
+		}
+		merged.Content = strings.Join(contents, "\n")
+		log.Printf("Merge messages, user: %s, count: %d, msgId: %d", key, len(msgs), merged.MsgId)
+	}
+	submitLKETask(&merged, func(content string) { sendText(&merged, content) })
+}
+
+// runPipeline 依次执行全部中间件处理一条消息，未回复时写入空响应
+func runPipeline(c *MessageContext) {
+	pipelineMutex.RLock()
+	chain := make([]Middleware, 0, len(middlewares)+3)
+	chain = append(chain, LoggingMiddleware)
+	chain = append(chain, middlewares...)
+	chain = append(chain, TextOnlyMiddleware, InstantAnswerMiddleware)
+	pipelineMutex.RUnlock()
+
+	handler := MessageHandler(callLKEHandler)
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	handler(c)
+
+	if !c.replied {
+		c.w.Write(nil)
+	}
+}
+
+// filterReply 依次执行全部出站回复过滤器
+func filterReply(msg *wecomEntity.WxBizMsg, reply string) string {
+	pipelineMutex.RLock()
+	filters := append([]ReplyFilter(nil), replyFilters...)
+	pipelineMutex.RUnlock()
+
+	for _, f := range filters {
+		if reply = f(msg, reply); len(reply) == 0 {
+			return ""
+		}
+	}
+	return reply
+}
@@ -0,0 +1,64 @@
+package logic
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"example.com/play/config"
+	"example.com/play/logic/render"
+)
+
+// referenceStyle 引用的展示模板，为空时使用默认模板
+var referenceStyle atomic.Pointer[render.ReferenceStyle]
+
+// SetReferenceStyle 设置引用的展示模板，支持运行中替换
+func SetReferenceStyle(style *render.ReferenceStyle) {
+	referenceStyle.Store(style)
+}
+
+// linkPolicy 参考资料链接的处理策略，为空时保留原始链接
+var linkPolicy atomic.Pointer[render.LinkPolicy]
+
+// SetLinkPolicy 设置参考资料链接的处理策略
+func SetLinkPolicy(policy *render.LinkPolicy) {
+	linkPolicy.Store(policy)
+}
+
+// userThoughtModes 用户通过指令设置的思考过程展示方式，key 为用户ID，进程重启后失效
+var userThoughtModes sync.Map
+
+func userThoughtMode(userID string) (render.ThoughtMode, bool) {
+	mode, ok := userThoughtModes.Load(userID)
+	if !ok {
+		return "", false
+	}
+	return mode.(render.ThoughtMode), true
+}
+
+// setUserThoughtMode 设置用户的思考过程展示方式，mode 为空时恢复应用默认
+func setUserThoughtMode(userID string, mode render.ThoughtMode) {
+	if mode == "" {
+		userThoughtModes.Delete(userID)
+		return
+	}
+	userThoughtModes.Store(userID, mode)
+}
+
+// appThoughtMode 应用配置的思考过程展示方式，为空时由渲染方式决定
+func appThoughtMode() render.ThoughtMode {
+	return render.ThoughtMode(config.Get().LKEThoughtMode)
+}
+
+// renderOptions 返回用户在应用 profile 中本次对话的渲染选项，用户设置优先于应用配置
+func renderOptions(profile *appProfile, userID string) (render.Options, error) {
+	mode, ok := userThoughtMode(userID)
+	if !ok {
+		mode = appThoughtMode()
+	}
+	chunker, err := render.NewChunker(profile.LKEChunking)
+	if err != nil {
+		return render.Options{}, err
+	}
+	return render.Options{ThoughtMode: mode, Chunker: chunker, References: referenceStyle.Load(),
+		LinkPolicy: linkPolicy.Load()}, nil
+}
@@ -0,0 +1,187 @@
+package logic
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"example.com/play/config"
+	wecomClient "example.com/play/repo/wecom/client"
+	"example.com/play/repo/wecom/cron"
+	wecomEntity "example.com/play/repo/wecom/entity"
+	"example.com/play/repo/wecom/keyring"
+	"example.com/play/repo/wecom/suite"
+)
+
+// appProfile 运行中的企业微信应用：应用配置及其回调密钥环、access token 来源和企业微信客户端。
+// 第三方应用的 tokens 为 suite_access_token，没有 api，消息由各授权企业对应的 appProfile 处理。
+type appProfile struct {
+	config.Profile
+	routes []*lkeRoute
+	keys   *keyring.KeyRing
+	tokens *cron.Source
+	api    *wecomClient.Client
+	suite  *suiteApp
+}
+
+// agentKey 按企业ID和应用ID标识一个企业微信应用
+type agentKey struct {
+	corpID  string
+	agentID int64
+}
+
+// profileSet 全部企业微信应用，按回调路径和应用ID索引
+type profileSet struct {
+	list    []*appProfile
+	byPath  map[string]*appProfile
+	byAgent map[agentKey]*appProfile
+	// suites 第三方应用，按指令回调路径索引
+	suites map[string]*appProfile
+}
+
+// profiles 当前生效的全部应用，重载时整体替换
+var profiles atomic.Pointer[profileSet]
+
+// ValidateProfiles 校验应用的提示文案、路由规则和第三方应用的授权信息目录，返回全部错误，
+// 不修改当前生效的应用，可与其他配置一起校验后再调用 SetProfiles
+func ValidateProfiles(list []config.Profile) []string {
+	var problems []string
+	for _, p := range list {
+		for name := range p.Replies {
+			if _, ok := defaultReplies[replyKey(name)]; !ok {
+				problems = append(problems, fmt.Sprintf("profile %s: unknown reply %q", p.Name, name))
+			}
+		}
+		if _, err := compileRoutes(p.Routes); err != nil {
+			problems = append(problems, fmt.Sprintf("profile %s: %v", p.Name, err))
+		}
+		if p.WxSuiteID != "" {
+			if _, err := suite.NewFileStore(p.WxSuiteStore, p.WxSuiteID); err != nil {
+				problems = append(problems, fmt.Sprintf("profile %s: %v", p.Name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// SetProfiles 校验并设置全部企业微信应用，access token 来源由 tokens 按应用凭证创建。
+// 回调路径和企业ID不变的应用沿用原有的密钥环，只替换其中的密钥。
+func SetProfiles(list []config.Profile, tokens *cron.Manager) error {
+	if problems := ValidateProfiles(list); len(problems) > 0 {
+		return config.JoinProblems(problems)
+	}
+
+	routes := make([][]*lkeRoute, len(list))
+	for i, p := range list {
+		compiled, err := compileRoutes(p.Routes)
+		if err != nil {
+			return fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		routes[i] = compiled
+	}
+
+	suites := make([]*suiteApp, len(list))
+	for i, p := range list {
+		if p.WxSuiteID == "" {
+			continue
+		}
+		app, err := newSuiteApp(p, tokens)
+		if err != nil {
+			return fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		suites[i] = app
+	}
+
+	previous := profiles.Load()
+	set := &profileSet{byPath: map[string]*appProfile{}, byAgent: map[agentKey]*appProfile{}, suites: map[string]*appProfile{}}
+	names := make([]string, 0, len(list))
+	for i, p := range list {
+		var ring *keyring.KeyRing
+		if previous != nil {
+			if old, ok := previous.byPath[p.Path]; ok && old.WxCorpID == p.WxCorpID {
+				ring = old.keys
+				ring.SetKeys(p.CryptKeys)
+			}
+		}
+		if ring == nil {
+			receiverID := p.WxCorpID
+			if p.WxSuiteID != "" {
+				// 第三方应用接收多个授权企业的消息，不校验 ReceiveId，改为校验授权信息
+				receiverID = ""
+			}
+			ring = keyring.New(receiverID, p.CryptKeys)
+		}
+		profile := &appProfile{Profile: p, routes: routes[i], keys: ring}
+		set.list = append(set.list, profile)
+		set.byPath[p.Path] = profile
+		if app := suites[i]; app != nil {
+			profile.suite = app
+			profile.tokens = app.suite.Token()
+			set.suites[p.WxSuitePath] = profile
+		} else {
+			profile.tokens = tokens.Source(p.WxCorpID, p.WxAppSecret)
+			profile.api = wecomClient.New(profile.tokens)
+			set.byAgent[agentKey{corpID: p.WxCorpID, agentID: p.WxAgentID}] = profile
+		}
+		names = append(names, fmt.Sprintf("%s(path: %q, agent: %d)", p.Name, p.Path, p.WxAgentID))
+	}
+	profiles.Store(set)
+	log.Printf("Profiles updated: %v", names)
+	return nil
+}
+
+// PrefetchTokens 启动时预先获取各应用的 access token，第三方应用为 suite_access_token，失败时在后台重试
+func PrefetchTokens() {
+	set := profiles.Load()
+	if set == nil {
+		return
+	}
+	for _, p := range set.list {
+		if _, err := p.tokens.Token(); err != nil {
+			log.Printf("Get access token failed, will retry, profile: %s, err: %v", p.Name, err)
+		}
+	}
+}
+
+// profileByPath 返回回调路径对应的应用，未配置回调路径的单个应用接收任意路径。
+// instruction 表示该路径为第三方应用的指令回调路径。
+func profileByPath(path string) (profile *appProfile, instruction bool, ok bool) {
+	set := profiles.Load()
+	if set == nil {
+		return nil, false, false
+	}
+	if p, ok := set.suites[path]; ok {
+		return p, true, true
+	}
+	if p, ok := set.byPath[path]; ok {
+		return p, false, true
+	}
+	p, ok := set.byPath[""]
+	return p, false, ok
+}
+
+// profileOf 返回消息所属的应用，未配置应用ID的单个应用接收该企业全部应用的消息。
+// 应用在处理消息期间被重载删除时返回 nil。
+func profileOf(msg *wecomEntity.WxBizMsg) *appProfile {
+	set := profiles.Load()
+	if set == nil {
+		return nil
+	}
+	if p, ok := set.byAgent[agentKey{corpID: msg.ToUserName, agentID: msg.AgentID}]; ok {
+		return p
+	}
+	if p, ok := set.byAgent[agentKey{corpID: msg.ToUserName}]; ok {
+		return p
+	}
+	for _, p := range set.suites {
+		if corp := p.suite.corpProfile(p, msg.ToUserName); corp != nil && (corp.WxAgentID == 0 || corp.WxAgentID == msg.AgentID) {
+			return corp
+		}
+	}
+	return nil
+}
+
+// userKey 标识某个企业的某个应用中的一个用户，用于区分同一用户在不同应用中的排队和最近一次回答
+func userKey(msg *wecomEntity.WxBizMsg) string {
+	return fmt.Sprintf("%s:%d:%s", msg.ToUserName, msg.AgentID, msg.FromUserName)
+}
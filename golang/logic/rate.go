@@ -0,0 +1,89 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/play/repo/tencentlke/capi"
+	wecomEntity "example.com/play/repo/wecom/entity"
+)
+
+var (
+	// lkeCapiClient 大模型知识引擎云API客户端，未配置腾讯云 API 密钥时为 nil
+	lkeCapiClient atomic.Pointer[capi.Client]
+	// lastRecords 用户最近一次回答的记录，key 为 userKey，进程重启后失效
+	lastRecords sync.Map
+)
+
+// lastRecord 用户最近一次回答的知识引擎应用和记录ID
+type lastRecord struct {
+	appKey   string
+	recordID string
+}
+
+// SetCapiClient 设置调用大模型知识引擎管理接口的云API客户端，nil 表示关闭回答评价
+func SetCapiClient(client *capi.Client) {
+	lkeCapiClient.Store(client)
+}
+
+// setLastRecord 记录用户最近一次回答的知识引擎应用和记录ID，用于评价回答
+func setLastRecord(key, appKey, recordID string) {
+	if recordID != "" {
+		lastRecords.Store(key, lastRecord{appKey: appKey, recordID: recordID})
+	}
+}
+
+func handleRateCommand(msg *wecomEntity.WxBizMsg, args []string) string {
+	client := lkeCapiClient.Load()
+	if client == nil {
+		return "未开启回答评价"
+	}
+	if len(args) == 0 {
+		return rateUsage
+	}
+	var score uint64
+	switch args[0] {
+	case "up", "好":
+		score = capi.ScoreLike
+	case "down", "差":
+		score = capi.ScoreDislike
+	default:
+		return rateUsage
+	}
+	value, ok := lastRecords.Load(userKey(msg))
+	if !ok {
+		return "没有可以评价的回答"
+	}
+	record := value.(lastRecord)
+	req := &capi.RateMsgRecordRequest{
+		BotAppKey: record.appKey,
+		RecordID:  record.recordID,
+		Score:     score,
+	}
+	if score == capi.ScoreDislike && len(args) > 1 {
+		req.Reasons = []string{strings.Join(args[1:], " ")}
+	}
+	// 指令在回调请求中同步处理，需在企业微信的回调超时前返回
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := client.RateMsgRecord(ctx, req); err != nil {
+		log.Printf("Rate msg record failed, msgId: %d, recordId: %s, err: %v", msg.MsgId, req.RecordID, err)
+		if errors.Is(err, capi.ErrLimitExceeded) {
+			return replyFor(msg, replyBusy)
+		}
+		return "抱歉，评价失败，请稍后再试 :-<"
+	}
+	if score == capi.ScoreLike {
+		return "感谢您的认可！"
+	}
+	return "感谢反馈，我们会持续改进 :-)"
+}
+
+const rateUsage = "用法：/rate up|down [原因]\n" +
+	"up 对最近一次回答点赞\n" +
+	"down 对最近一次回答点踩，可附上原因"
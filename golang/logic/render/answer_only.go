@@ -0,0 +1,48 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	lkeClient "example.com/play/repo/tencentlke/client"
+)
+
+// answerOnlyRenderer 只在回答结束后发送一条完整的回答，不输出过程步骤。
+// 思考过程不实时输出，ThoughtFull 按 ThoughtCollapsed 处理。
+type answerOnlyRenderer struct {
+	thoughtMode ThoughtMode
+
+	content          string
+	references       *referenceTracker
+	reasoningTitle   string
+	reasoningContent string
+	reasoningElapsed float64
+}
+
+// Render 实现 Renderer
+func (r *answerOnlyRenderer) Render(ev lkeClient.Event) []Message {
+	switch ev := ev.(type) {
+	case *lkeClient.ReferenceEvent:
+		r.references.add(ev.References)
+	case *lkeClient.ThoughtEvent:
+		r.reasoningTitle = ev.Title
+		r.reasoningContent = ev.Content
+		r.reasoningElapsed = float64(ev.Elapsed.Milliseconds())
+	case *lkeClient.ReplyDeltaEvent:
+		r.content = ev.Content
+	}
+	return nil
+}
+
+// Flush 实现 Renderer
+func (r *answerOnlyRenderer) Flush() []Message {
+	content := strings.TrimSpace(r.content)
+	if r.thoughtMode == ThoughtNotice && r.reasoningElapsed > 0 {
+		content = fmt.Sprintf("> <font color=\"comment\">%s共用时%.3f秒</font>\n\n%s", r.reasoningTitle, r.reasoningElapsed/1000, content)
+	}
+	messages := appendFootnotes(markdown(r.references.inline(content)), r.references.footnotes())
+	if r.thoughtMode == ThoughtCollapsed || r.thoughtMode == ThoughtFull {
+		messages = append(messages, collapsedThought(r.reasoningTitle, r.reasoningContent, r.reasoningElapsed)...)
+	}
+	return messages
+}
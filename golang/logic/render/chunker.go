@@ -0,0 +1,126 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Chunker 决定流式回答何时切分为一条消息发送。每次对话使用一个新的 Chunker。
+type Chunker interface {
+	// Cut 传入截至目前的完整回答 content 和已发送到的位置 sent，
+	// 返回本次应发送到的位置 end（content[sent:end] 为新的一条消息），不需要发送时返回 sent
+	Cut(content string, sent int, now time.Time) int
+}
+
+// NewChunker 按配置创建切分策略，支持：
+//
+//	paragraph      每遇到一段完整的段落发送一次（默认）
+//	paragraph:N    段落累计至少 N 字节才发送
+//	interval:N     每隔 N 秒发送一次已生成的完整行
+//	size:N         累计至少 N 字节发送一次，尽量在段落、换行或句末处切分
+//	final          回答结束后一次性发送
+func NewChunker(spec string) (Chunker, error) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), ":")
+	n := 0
+	if hasArg {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid chunking %q: argument must be a positive integer", spec)
+		}
+	}
+	switch name {
+	case "", "paragraph":
+		return &paragraphChunker{minSize: n}, nil
+	case "interval":
+		if !hasArg {
+			return nil, fmt.Errorf("invalid chunking %q: want interval:<seconds>", spec)
+		}
+		return &intervalChunker{interval: time.Duration(n) * time.Second}, nil
+	case "size":
+		if !hasArg {
+			return nil, fmt.Errorf("invalid chunking %q: want size:<bytes>", spec)
+		}
+		return &sizeChunker{size: n}, nil
+	case "final":
+		if hasArg {
+			return nil, fmt.Errorf("invalid chunking %q: final takes no argument", spec)
+		}
+		return finalChunker{}, nil
+	}
+	return nil, fmt.Errorf("unknown chunking %q, available: paragraph[:N], interval:N, size:N, final", spec)
+}
+
+// paragraphChunker 在最后一个完整段落处切分，minSize 大于 0 时累计不足 minSize 字节的段落继续等待
+type paragraphChunker struct {
+	minSize int
+}
+
+func (c *paragraphChunker) Cut(content string, sent int, now time.Time) int {
+	end := strings.LastIndex(content, "\n\n")
+	if end <= sent || end-sent < c.minSize {
+		return sent
+	}
+	return end
+}
+
+// intervalChunker 距上次发送超过 interval 后，在最后一个换行处切分
+type intervalChunker struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func (c *intervalChunker) Cut(content string, sent int, now time.Time) int {
+	if c.last.IsZero() {
+		c.last = now
+	}
+	if now.Sub(c.last) < c.interval {
+		return sent
+	}
+	end := strings.LastIndex(content, "\n")
+	if end <= sent {
+		return sent
+	}
+	c.last = now
+	return end
+}
+
+// sizeChunker 累计至少 size 字节后切分，优先在段落、换行、句末处切分，都没有时按 size 截断
+type sizeChunker struct {
+	size int
+}
+
+// sizeSeparators 按优先级排列的切分点，切分在分隔符之后
+var sizeSeparators = []string{"\n\n", "\n", "。", "！", "？", ". ", "! ", "? "}
+
+func (c *sizeChunker) Cut(content string, sent int, now time.Time) int {
+	if len(content)-sent < c.size {
+		return sent
+	}
+	for _, sep := range sizeSeparators {
+		// 分隔符紧跟在第 size 字节之后时同样可以切分，避免句末标点单独成为一条消息
+		window := content[sent:min(len(content), sent+c.size+len(sep))]
+		for i := strings.LastIndex(window, sep); i > 0; i = strings.LastIndex(window[:i], sep) {
+			// 数字后的“. ”是列表序号，如“2. ”，不是句末
+			if sep == ". " && content[sent+i-1] >= '0' && content[sent+i-1] <= '9' {
+				continue
+			}
+			return sent + i + len(sep)
+		}
+	}
+	// 没有合适的切分点，按 size 截断并保证不拆开多字节字符
+	end := sent + c.size
+	for end > sent && end < len(content) && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return end
+}
+
+// finalChunker 不切分，回答结束后一次性发送
+type finalChunker struct{}
+
+func (finalChunker) Cut(content string, sent int, now time.Time) int {
+	return sent
+}
@@ -0,0 +1,182 @@
+package render
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	lkeClient "example.com/play/repo/tencentlke/client"
+	lkeEntity "example.com/play/repo/tencentlke/entity"
+)
+
+// replay 通过对话接口客户端回放 testdata 中录制的 SSE 流，返回客户端转换后的全部事件
+func replay(t *testing.T, name string) []lkeClient.Event {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	client := lkeClient.New(lkeClient.WithBaseURL(server.URL), lkeClient.WithLogger(log.New(io.Discard, "", 0)))
+	events, err := client.Chat(context.Background(), &lkeEntity.SseSendEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []lkeClient.Event
+	for ev := range events {
+		if errEv, ok := ev.(*lkeClient.ErrorEvent); ok {
+			t.Fatalf("replay %s: %v", name, errEv)
+		}
+		list = append(list, ev)
+	}
+	return list
+}
+
+// render 按切分策略渲染事件，当前时间取自回复事件中录制的时间戳，返回发送的全部消息内容
+func render(t *testing.T, events []lkeClient.Event, chunking string) []string {
+	t.Helper()
+	chunker, err := NewChunker(chunking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newParagraphRenderer(paragraphOptions{thoughtMode: ThoughtHidden, chunker: chunker})
+	var now time.Time
+	r.now = func() time.Time { return now }
+
+	var got []string
+	collect := func(messages []Message) {
+		for _, m := range messages {
+			got = append(got, m.Content)
+		}
+	}
+	for _, ev := range events {
+		if reply, ok := ev.(*lkeClient.ReplyDeltaEvent); ok {
+			now = time.Unix(reply.Raw.Payload.Timestamp, 0)
+		}
+		collect(r.Render(ev))
+	}
+	collect(r.Flush())
+	return got
+}
+
+func TestChunkers(t *testing.T) {
+	vpnSteps := []string{
+		"重置 VPN 密码的步骤如下：",
+		"1. 打开自助服务门户，选择“账号安全”。",
+		"2. 点击“重置 VPN 密码”，按提示完成短信验证。",
+		"3. 设置新密码，长度至少 12 位，需包含大小写字母和数字。",
+		"4. 在 VPN 客户端中使用新密码重新登录。",
+		"如仍无法登录，请联系 IT 服务台（分机 8000）。",
+	}
+	printer := "三楼的共享打印机位于茶水间旁边，支持彩色打印和双面打印。首次使用需要在电脑上安装驱动，驱动可以在自助服务门户的“软件下载”中找到。"
+
+	tests := []struct {
+		name       string
+		transcript string
+		chunking   string
+		want       []string
+	}{
+		{
+			name:       "paragraph",
+			transcript: "vpn_steps.sse",
+			chunking:   "paragraph",
+			want:       vpnSteps,
+		},
+		{
+			// 标题不足 60 字节与第一项合并，最后一项不足 60 字节等到回答结束与结尾合并
+			name:       "paragraph with minimum size",
+			transcript: "vpn_steps.sse",
+			chunking:   "paragraph:60",
+			want: []string{
+				vpnSteps[0] + "\n\n" + vpnSteps[1],
+				vpnSteps[2],
+				vpnSteps[3],
+				vpnSteps[4] + "\n\n" + vpnSteps[5],
+			},
+		},
+		{
+			// 第 3、6、9 秒各在最后一个换行处发送一次
+			name:       "interval",
+			transcript: "vpn_steps.sse",
+			chunking:   "interval:3",
+			want: []string{
+				vpnSteps[0] + "\n\n" + vpnSteps[1],
+				vpnSteps[2],
+				vpnSteps[3] + "\n\n" + vpnSteps[4],
+				vpnSteps[5],
+			},
+		},
+		{
+			// 没有换行时等到回答结束
+			name:       "interval without line breaks",
+			transcript: "printer.sse",
+			chunking:   "interval:3",
+			want:       []string{printer},
+		},
+		{
+			// 列表序号“2. ”不作为句末；达到 60 字节时句末尚未到达，按 60 字节截断
+			name:       "size",
+			transcript: "vpn_steps.sse",
+			chunking:   "size:60",
+			want: []string{
+				vpnSteps[0],
+				vpnSteps[1],
+				"2. 点击“重置 VPN 密码”，按提示完成短信验",
+				"证。",
+				"3. 设置新密码，长度至少 12 位，需包含大小",
+				"写字母和数字。",
+				vpnSteps[4],
+				vpnSteps[5],
+			},
+		},
+		{
+			name:       "size in paragraphs",
+			transcript: "vpn_steps.sse",
+			chunking:   "size:120",
+			want: []string{
+				vpnSteps[0] + "\n\n" + vpnSteps[1],
+				vpnSteps[2],
+				vpnSteps[3],
+				vpnSteps[4] + "\n\n" + vpnSteps[5],
+			},
+		},
+		{
+			name:       "size in sentences",
+			transcript: "printer.sse",
+			chunking:   "size:60",
+			want: []string{
+				"三楼的共享打印机位于茶水间旁边，支持彩色",
+				"打印和双面打印。",
+				"首次使用需要在电脑上安装驱动，驱动可以在",
+				"自助服务门户的“软件下载”中找到。",
+			},
+		},
+		{
+			name:       "final",
+			transcript: "vpn_steps.sse",
+			chunking:   "final",
+			want:       []string{strings.Join(vpnSteps, "\n\n")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(t, replay(t, tt.transcript), tt.chunking)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunking %s got %d messages:\n%q\nwant %d messages:\n%q", tt.chunking, len(got), got, len(tt.want), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package render
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// LinkPolicy 参考资料链接的处理策略，依次执行：去除全部链接、按模板改写文档链接、按域名白名单过滤。
+// 被去除的链接只展示资料名称。
+type LinkPolicy struct {
+	strip   bool
+	rewrite *template.Template
+	domains []string
+}
+
+// NewLinkPolicy 创建链接处理策略：
+// strip 为 true 时去除全部链接；
+// rewrite 为 text/template 模板，数据为 ReferenceSource，如 https://portal.example.com/docs/{{.DocBizID}}，
+// 只改写 DocBizID 不为空的文档引用，结果为空时不改写；
+// domains 为允许的域名，包含其子域名，为空时不限制，改写后的链接同样需要在白名单内。
+func NewLinkPolicy(strip bool, rewrite string, domains []string) (*LinkPolicy, error) {
+	p := &LinkPolicy{strip: strip}
+	if rewrite != "" {
+		tmpl, err := template.New("rewrite").Parse(rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse link rewrite template: %v", err)
+		}
+		p.rewrite = tmpl
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+		if domain != "" {
+			p.domains = append(p.domains, domain)
+		}
+	}
+	return p, nil
+}
+
+// Apply 返回资料处理后的链接，返回空字符串表示不展示链接
+func (p *LinkPolicy) Apply(src ReferenceSource) string {
+	if p == nil {
+		return src.URL
+	}
+	if p.strip {
+		return ""
+	}
+	link := src.URL
+	if p.rewrite != nil && src.IsDocument() {
+		var b strings.Builder
+		if err := p.rewrite.Execute(&b, src); err != nil {
+			log.Printf("Execute link rewrite template failed, keep original link, doc: %s, err: %v", src.DocBizID, err)
+		} else if rewritten := strings.TrimSpace(b.String()); rewritten != "" {
+			link = rewritten
+		}
+	}
+	if link == "" || !p.allowed(link) {
+		return ""
+	}
+	return link
+}
+
+// allowed 检查链接是否可以展示，未配置白名单时不限制
+func (p *LinkPolicy) allowed(rawURL string) bool {
+	return len(p.domains) == 0 || p.Allowed(rawURL)
+}
+
+// Allowed 检查链接是否为 http(s) 且域名在白名单内，p 为空或未配置白名单时只检查协议。
+// 服务端下载链接内容前使用。
+func (p *LinkPolicy) Allowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if p == nil || len(p.domains) == 0 {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range p.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
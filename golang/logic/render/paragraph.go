@@ -0,0 +1,190 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	lkeClient "example.com/play/repo/tencentlke/client"
+	lkeEntity "example.com/play/repo/tencentlke/entity"
+)
+
+// paragraphOptions 按段落输出的渲染选项
+type paragraphOptions struct {
+	procedureNotice  bool // 输出“xx，请稍等...”的过程步骤提示
+	procedureDetails bool // 输出每个过程步骤的状态变化和耗时
+	thoughtMode      ThoughtMode
+	chunker          Chunker         // 回答的切分策略，为空时按段落切分
+	references       *ReferenceStyle // 引用的展示模板，为空时使用默认模板
+	linkPolicy       *LinkPolicy     // 参考资料链接的处理策略，为空时保留原始链接
+}
+
+// paragraphRenderer 每遇到一段完整的思考输出一次，回答按切分策略分段输出，避免等待过久体验不佳以及回答过长企微强制截断
+type paragraphRenderer struct {
+	opts paragraphOptions
+	now  func() time.Time
+
+	content                  string
+	contentSent              int
+	reasoningContent         string
+	reasoningContentSnapshot string
+	reasoningProcedureName   string
+	reasoningElapsed         float64
+	reasoningElapsedSent     bool
+	references               *referenceTracker
+	latestProcedureName      string
+	procedureStatus          map[string]string
+}
+
+func newParagraphRenderer(opts paragraphOptions) *paragraphRenderer {
+	if opts.chunker == nil {
+		opts.chunker = &paragraphChunker{}
+	}
+	return &paragraphRenderer{opts: opts, now: time.Now, references: newReferenceTracker(opts.references, opts.linkPolicy), procedureStatus: map[string]string{}}
+}
+
+// Render 实现 Renderer
+func (r *paragraphRenderer) Render(ev lkeClient.Event) []Message {
+	switch ev := ev.(type) {
+	case *lkeClient.TokenStatEvent:
+		return r.renderProcedures(ev.Procedures)
+	case *lkeClient.ReferenceEvent:
+		r.references.add(ev.References)
+	case *lkeClient.ThoughtEvent:
+		return r.renderThought(ev)
+	case *lkeClient.ReplyDeltaEvent:
+		return r.renderReply(ev)
+	}
+	return nil
+}
+
+func (r *paragraphRenderer) renderProcedures(procedures []lkeEntity.Procedure) []Message {
+	var messages []Message
+	if r.opts.procedureDetails {
+		for _, procedure := range procedures {
+			name := strings.TrimSpace(procedure.Title)
+			if len(name) == 0 || r.procedureStatus[name] == procedure.Status {
+				continue
+			}
+			r.procedureStatus[name] = procedure.Status
+			if procedure.Status == "success" && procedure.Elapsed > 0 {
+				messages = append(messages, markdown(fmt.Sprintf("> <font color=\"comment\">%s：%s，耗时%.3f秒</font>",
+					name, procedureStatusText(procedure.Status), float64(procedure.Elapsed)/1000))...)
+			} else if procedure.Status != "processing" {
+				messages = append(messages, markdown(fmt.Sprintf("> <font color=\"comment\">%s：%s</font>",
+					name, procedureStatusText(procedure.Status)))...)
+			}
+		}
+	}
+	if r.opts.procedureNotice && len(procedures) > 0 {
+		procedureName := strings.TrimSpace(procedures[len(procedures)-1].Title)
+		if procedureName != r.latestProcedureName {
+			r.latestProcedureName = procedureName
+			messages = append(messages, markdown(fmt.Sprintf("> %s，请稍等...", r.latestProcedureName))...)
+		}
+	}
+	return messages
+}
+
+func (r *paragraphRenderer) renderThought(ev *lkeClient.ThoughtEvent) []Message {
+	r.reasoningContent = ev.Content
+	r.reasoningElapsed = float64(ev.Elapsed.Milliseconds())
+	r.reasoningProcedureName = ev.Title
+	if r.opts.thoughtMode != ThoughtFull {
+		// 不实时输出，快照始终保持为最新的思考内容
+		r.reasoningContentSnapshot = r.reasoningContent
+		return nil
+	}
+	// 先处理段落，再处理单句。单句一般就是思考的最后一段话。
+	if !strings.HasSuffix(r.reasoningContent, "\n\n") {
+		return nil
+	}
+	// 先裁剪出新增的文字
+	reasoningContentCut, _ := strings.CutPrefix(r.reasoningContent, r.reasoningContentSnapshot)
+	formattedReasoningContentCut := strings.TrimSpace(reasoningContentCut)
+	// 再保存快照
+	r.reasoningContentSnapshot = r.reasoningContent
+	if len(formattedReasoningContentCut) == 0 {
+		return nil
+	}
+	procedureInvoice := fmt.Sprintf("> %s中...\n> \n> %s", r.reasoningProcedureName, formattedReasoningContentCut)
+	return markdown(r.references.inline(procedureInvoice))
+}
+
+func (r *paragraphRenderer) renderReply(ev *lkeClient.ReplyDeltaEvent) []Message {
+	var messages []Message
+	// 如果思考内容还有部分没输出，需要输出，思考过程的最后才是回复，需要附上思考耗时
+	if len(r.reasoningContent) > len(r.reasoningContentSnapshot) {
+		reasoningContentCut, _ := strings.CutPrefix(r.reasoningContent, r.reasoningContentSnapshot)
+		// 快照保存为最终的思考内容，避免重复发送
+		r.reasoningContentSnapshot = r.reasoningContent
+		formattedReasoningContentCut := strings.TrimSpace(reasoningContentCut)
+		if len(formattedReasoningContentCut) != 0 {
+			procedureInvoice := fmt.Sprintf("> %s中...\n> \n> %s", r.reasoningProcedureName, formattedReasoningContentCut)
+			if elapsed := r.takeReasoningElapsed(); len(elapsed) > 0 {
+				procedureInvoice = fmt.Sprintf("%s\n%s", procedureInvoice, elapsed)
+			}
+			messages = append(messages, markdown(r.references.inline(procedureInvoice))...)
+		}
+	}
+	r.content = ev.Content
+	if r.contentSent > len(r.content) {
+		// 回复内容被改写（如敏感词替换），不再是之前内容的延续
+		r.contentSent = len(r.content)
+	}
+	for {
+		end := r.opts.chunker.Cut(r.content, r.contentSent, r.now())
+		if end <= r.contentSent {
+			break
+		}
+		// 裁剪出本次需要发送的文字
+		contentCut := r.content[r.contentSent:end]
+		r.contentSent = end
+		formattedContentCut := strings.TrimSpace(contentCut)
+		if elapsed := r.takeReasoningElapsed(); len(elapsed) > 0 {
+			formattedContentCut = fmt.Sprintf("%s\n\n%s", elapsed, formattedContentCut)
+		}
+		messages = append(messages, markdown(r.references.inline(formattedContentCut))...)
+	}
+	return messages
+}
+
+// Flush 实现 Renderer：发送最后的一段回复和参考资料，思考过程合并展示时随后发送完整思考过程
+func (r *paragraphRenderer) Flush() []Message {
+	formattedContentCut := strings.TrimSpace(r.content[r.contentSent:])
+	if elapsed := r.takeReasoningElapsed(); len(elapsed) > 0 {
+		formattedContentCut = fmt.Sprintf("%s\n\n%s", elapsed, formattedContentCut)
+	}
+	messages := appendFootnotes(markdown(r.references.inline(formattedContentCut)), r.references.footnotes())
+	if r.opts.thoughtMode == ThoughtCollapsed {
+		messages = append(messages, collapsedThought(r.reasoningProcedureName, r.reasoningContent, r.reasoningElapsed)...)
+	}
+	return messages
+}
+
+// takeReasoningElapsed 返回尚未输出的思考耗时提示，只输出一次，仅实时输出和只提示耗时两种方式需要
+func (r *paragraphRenderer) takeReasoningElapsed() string {
+	if r.opts.thoughtMode != ThoughtFull && r.opts.thoughtMode != ThoughtNotice {
+		return ""
+	}
+	if r.reasoningElapsedSent || r.reasoningElapsed <= 0 {
+		return ""
+	}
+	r.reasoningElapsedSent = true
+	return fmt.Sprintf("> <font color=\"comment\">%s共用时%.3f秒</font>", r.reasoningProcedureName, r.reasoningElapsed/1000)
+}
+
+func procedureStatusText(status string) string {
+	switch status {
+	case "processing":
+		return "进行中"
+	case "success":
+		return "完成"
+	case "failed":
+		return "失败"
+	case "stop":
+		return "已停止"
+	default:
+		return status
+	}
+}
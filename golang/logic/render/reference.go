@@ -0,0 +1,209 @@
+package render
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+
+	lkeEntity "example.com/play/repo/tencentlke/entity"
+)
+
+// defaultReferenceTemplate 默认的引用展示模板：
+// "inline" 渲染回答中的引用标记，数据为引用ID；
+// "footnotes" 渲染回答末尾的参考资料，数据为 []ReferenceSource，列表为空时不会渲染
+const defaultReferenceTemplate = `{{define "inline"}}【{{.}}】{{end}}` +
+	`{{define "footnotes"}}> <font color="comment">参考资料</font>` +
+	`{{range .}}{{"\n"}}> {{range .IDs}}【{{.}}】{{end}} ` +
+	`{{if .IsQA}}问答：{{.Name}}{{else if .URL}}[{{.Name}}]({{.URL}}){{else}}{{.Name}}{{end}}` +
+	`{{end}}{{end}}`
+
+// referencePlaceholder 回答中引用资料的占位符，如 [1]，只有ID属于已收到的引用时才替换
+var referencePlaceholder = regexp.MustCompile(`\[(\d+)\]`)
+
+// ReferenceSource 一份被引用的资料，多个引用指向同一份资料时合并
+type ReferenceSource struct {
+	IDs      []string // 指向该资料的全部引用ID
+	Name     string   // 文档名或问答的问题
+	URL      string
+	Type     int
+	IsQA     bool
+	DocBizID string
+	QABizID  string
+}
+
+// IsDocument 判断资料是否为知识库中的文档，问答对和联网检索的结果不是
+func (s ReferenceSource) IsDocument() bool {
+	return s.Type == lkeEntity.ReferenceTypeDoc && s.DocBizID != ""
+}
+
+// ReferenceStyle 引用的展示模板
+type ReferenceStyle struct {
+	tmpl      *template.Template
+	isDefault bool
+}
+
+// DefaultReferenceStyle 返回默认的引用展示模板
+func DefaultReferenceStyle() *ReferenceStyle {
+	style, err := ParseReferenceStyle(defaultReferenceTemplate)
+	if err != nil {
+		panic(err)
+	}
+	style.isDefault = true
+	return style
+}
+
+// ParseReferenceStyle 解析自定义的引用展示模板，模板需要定义 "inline" 和 "footnotes" 两部分
+func ParseReferenceStyle(text string) (*ReferenceStyle, error) {
+	tmpl, err := template.New("reference").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference template: %v", err)
+	}
+	for _, name := range []string{"inline", "footnotes"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("reference template must define %q", name)
+		}
+	}
+	return &ReferenceStyle{tmpl: tmpl}, nil
+}
+
+// execute 渲染模板中的 name 部分，自定义模板执行出错（如引用了不存在的字段）时记录日志并改用默认模板
+func (s *ReferenceStyle) execute(name string, data interface{}) string {
+	var b strings.Builder
+	err := s.tmpl.ExecuteTemplate(&b, name, data)
+	if err == nil {
+		return b.String()
+	}
+	if s.isDefault {
+		log.Printf("Execute default reference template %q failed, err: %v", name, err)
+		return ""
+	}
+	log.Printf("Execute reference template %q failed, use default template, err: %v", name, err)
+	return DefaultReferenceStyle().execute(name, data)
+}
+
+// referenceTracker 记录一次对话中收到的引用，将回答中的占位符替换为引用标记，
+// 并在回答结束时生成去重后的参考资料。引用可能晚于引用它的文字到达，因此行内只输出引用ID，
+// 资料名称和链接统一在参考资料中展示。
+type referenceTracker struct {
+	style  *ReferenceStyle
+	policy *LinkPolicy
+	refs   []lkeEntity.Reference
+	seen   map[string]bool
+}
+
+func newReferenceTracker(style *ReferenceStyle, policy *LinkPolicy) *referenceTracker {
+	if style == nil {
+		style = DefaultReferenceStyle()
+	}
+	return &referenceTracker{style: style, policy: policy, seen: map[string]bool{}}
+}
+
+// add 记录新收到的引用，相同ID只记录一次
+func (t *referenceTracker) add(refs []lkeEntity.Reference) {
+	for _, ref := range refs {
+		if t.seen[ref.ID] {
+			continue
+		}
+		t.seen[ref.ID] = true
+		t.refs = append(t.refs, ref)
+	}
+}
+
+// inline 将回答中已收到的引用的占位符替换为引用标记。其他 [N]（如数组下标 arr[0]、
+// 尚未收到的引用）保持原样；引用晚于文字到达时，已发送的文字保留 [N]，资料仍会列在参考资料中。
+func (t *referenceTracker) inline(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range referencePlaceholder.FindAllStringSubmatchIndex(text, -1) {
+		id := text[m[2]:m[3]]
+		if !t.seen[id] || (m[0] > 0 && isIdentifierByte(text[m[0]-1])) {
+			continue
+		}
+		b.WriteString(text[last:m[0]])
+		b.WriteString(t.style.execute("inline", id))
+		last = m[1]
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isIdentifierByte 判断 c 是否可以出现在标识符中，紧跟其后的 [N] 是下标而不是引用
+func isIdentifierByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// footnotes 生成参考资料，没有引用时返回空字符串
+func (t *referenceTracker) footnotes() string {
+	sources := t.sources()
+	if len(sources) == 0 {
+		return ""
+	}
+	return t.style.execute("footnotes", sources)
+}
+
+// sources 合并指向同一份资料的引用，链接按 LinkPolicy 处理
+func (t *referenceTracker) sources() []ReferenceSource {
+	sources := MergeReferences(t.refs)
+	for i := range sources {
+		sources[i].URL = t.policy.Apply(sources[i])
+	}
+	return sources
+}
+
+// MergeReferences 按引用ID首次出现的顺序合并指向同一份资料的引用，重复的引用ID只保留一次，链接保持原样
+func MergeReferences(refs []lkeEntity.Reference) []ReferenceSource {
+	var sources []ReferenceSource
+	index := map[string]int{}
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if seen[ref.ID] {
+			continue
+		}
+		seen[ref.ID] = true
+		isQA := ref.Type == lkeEntity.ReferenceTypeQA
+		key := "doc:" + ref.DocBizID
+		switch {
+		case isQA:
+			key = "qa:" + ref.QABizID
+		case ref.DocBizID == "":
+			key = "url:" + ref.URL + "|" + ref.DocName + "|" + ref.Name
+		}
+		if i, ok := index[key]; ok {
+			sources[i].IDs = append(sources[i].IDs, ref.ID)
+			continue
+		}
+		name := ref.DocName
+		if isQA || len(name) == 0 {
+			name = ref.Name
+		}
+		index[key] = len(sources)
+		sources = append(sources, ReferenceSource{
+			IDs:      []string{ref.ID},
+			Name:     name,
+			URL:      ref.URL,
+			Type:     ref.Type,
+			IsQA:     isQA,
+			DocBizID: ref.DocBizID,
+			QABizID:  ref.QABizID,
+		})
+	}
+	return sources
+}
+
+// appendFootnotes 将参考资料附加在最后一条消息之后，没有消息时单独发送
+func appendFootnotes(messages []Message, footnotes string) []Message {
+	if len(footnotes) == 0 {
+		return messages
+	}
+	if len(messages) == 0 {
+		return markdown(footnotes)
+	}
+	last := &messages[len(messages)-1]
+	last.Content = fmt.Sprintf("%s\n\n%s", last.Content, footnotes)
+	return messages
+}
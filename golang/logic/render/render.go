@@ -0,0 +1,152 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	lkeClient "example.com/play/repo/tencentlke/client"
+)
+
+// Message 发送给企业微信用户的一条消息
+type Message struct {
+	Content  string
+	Markdown bool
+}
+
+// Renderer 将大模型知识引擎的类型化事件渲染为企业微信消息。
+// 每次对话创建一个新的 Renderer，*lkeClient.ErrorEvent 由调用方处理，不会传给 Renderer。
+type Renderer interface {
+	// Render 处理一个事件，返回需要立即发送的消息
+	Render(ev lkeClient.Event) []Message
+	// Flush 事件流正常结束时调用，返回剩余需要发送的消息
+	Flush() []Message
+}
+
+// ThoughtMode 思考过程的展示方式
+type ThoughtMode string
+
+const (
+	ThoughtFull      ThoughtMode = "full"      // 实时按段落输出完整思考过程
+	ThoughtNotice    ThoughtMode = "notice"    // 只输出一行思考耗时提示
+	ThoughtCollapsed ThoughtMode = "collapsed" // 回答结束后将完整思考过程合并为一条消息发送
+	ThoughtHidden    ThoughtMode = "hidden"    // 不展示思考过程
+)
+
+// ParseThoughtMode 解析思考过程的展示方式
+func ParseThoughtMode(s string) (ThoughtMode, error) {
+	switch mode := ThoughtMode(s); mode {
+	case ThoughtFull, ThoughtNotice, ThoughtCollapsed, ThoughtHidden:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown thought mode %q, available: full, notice, collapsed, hidden", s)
+}
+
+// Options 渲染选项
+type Options struct {
+	ThoughtMode ThoughtMode     // 为空时使用渲染方式自身的默认值
+	Chunker     Chunker         // 回答的切分策略，为空时按段落切分；answer_only 不切分
+	References  *ReferenceStyle // 引用的展示模板，为空时使用默认模板
+	LinkPolicy  *LinkPolicy     // 参考资料链接的处理策略，为空时保留原始链接
+}
+
+// Factory 创建 Renderer
+type Factory func(opts Options) Renderer
+
+// DefaultName 默认渲染方式
+const DefaultName = "default"
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{}
+)
+
+func init() {
+	Register(DefaultName, func(opts Options) Renderer {
+		return newParagraphRenderer(paragraphOptions{procedureNotice: true, thoughtMode: orDefault(opts.ThoughtMode, ThoughtFull),
+			chunker: opts.Chunker, references: opts.References, linkPolicy: opts.LinkPolicy})
+	})
+	Register("compact", func(opts Options) Renderer {
+		return newParagraphRenderer(paragraphOptions{thoughtMode: orDefault(opts.ThoughtMode, ThoughtNotice),
+			chunker: opts.Chunker, references: opts.References, linkPolicy: opts.LinkPolicy})
+	})
+	Register("verbose", func(opts Options) Renderer {
+		return newParagraphRenderer(paragraphOptions{procedureNotice: true, procedureDetails: true,
+			thoughtMode: orDefault(opts.ThoughtMode, ThoughtFull), chunker: opts.Chunker, references: opts.References, linkPolicy: opts.LinkPolicy})
+	})
+	Register("answer_only", func(opts Options) Renderer {
+		return &answerOnlyRenderer{thoughtMode: orDefault(opts.ThoughtMode, ThoughtHidden),
+			references: newReferenceTracker(opts.References, opts.LinkPolicy)}
+	})
+}
+
+func orDefault(mode, defaultMode ThoughtMode) ThoughtMode {
+	if mode == "" {
+		return defaultMode
+	}
+	return mode
+}
+
+// Register 注册渲染方式，同名时覆盖
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[name] = factory
+}
+
+// New 按名称创建 Renderer，名称为空时使用默认渲染方式
+func New(name string, opts Options) (Renderer, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	registryMutex.RLock()
+	factory, ok := registry[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q, available: %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(opts), nil
+}
+
+// Names 返回已注册的全部渲染方式
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markdown 生成一条非空的Markdown消息
+func markdown(content string) []Message {
+	if len(content) == 0 {
+		return nil
+	}
+	return []Message{{Content: content, Markdown: true}}
+}
+
+// quote 将多行文本的每一行都转为引用格式
+func quote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// collapsedThought 将完整思考过程合并为一条引用格式的消息
+func collapsedThought(title string, content string, elapsedMs float64) []Message {
+	content = strings.TrimSpace(content)
+	if len(content) == 0 {
+		return nil
+	}
+	header := fmt.Sprintf("> <font color=\"comment\">%s过程", title)
+	if elapsedMs > 0 {
+		header = fmt.Sprintf("%s（共用时%.3f秒）", header, elapsedMs/1000)
+	}
+	return markdown(fmt.Sprintf("%s</font>\n>\n%s", header, quote(content)))
+}
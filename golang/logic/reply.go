@@ -0,0 +1,160 @@
+package logic
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/play/config"
+	wecomEntity "example.com/play/repo/wecom/entity"
+	"example.com/play/repo/wecom/keyring"
+)
+
+// replyKey 提示文案的名称，各应用可在配置的 replies 中按名称覆盖
+type replyKey string
+
+const (
+	replyUnsupportedMsgType replyKey = "unsupported_msg_type"
+	replyLKEFailed          replyKey = "lke_failed"
+	replyBusy               replyKey = "busy"
+	replyQueued             replyKey = "queued" // 含占位符 {position}：排队位置
+	replyShuttingDown       replyKey = "shutting_down"
+	replyInterrupted        replyKey = "interrupted"
+	replySourceFailed       replyKey = "source_failed" // 含占位符 {name}：文档名称
+	replyEmptyQuestion      replyKey = "empty_question"
+	replyPreviousPending    replyKey = "previous_pending"
+)
+
+// defaultReplies 默认的提示文案
+var defaultReplies = map[replyKey]string{
+	replyUnsupportedMsgType: "抱歉，目前仅支持文本输入，请尝试用文字与我交流 :-/",
+	replyLKEFailed:          "抱歉，调用大模型知识引擎出现了一点问题，请稍后再试 :-<",
+	replyBusy:               "抱歉，当前提问的人较多，请稍后再试 :-(",
+	replyQueued:             "当前提问的人较多，您的问题正在排队，排在第 {position} 位，请稍候...",
+	replyShuttingDown:       "抱歉，服务正在重启，您的问题未能处理，请稍后重新提问 :-(",
+	replyInterrupted:        "抱歉，服务正在重启，本次回答被中断，请稍后重新提问 :-(",
+	replySourceFailed:       "抱歉，《{name}》原文发送失败，请稍后再试 :-<",
+	replyEmptyQuestion:      "请在指令后输入您的问题",
+	replyPreviousPending:    "您的上一个问题还在回答中，完成后将继续回答这个问题，请稍候...",
+}
+
+// replyFor 返回消息所属应用的提示文案，应用未覆盖时使用默认文案。
+// placeholders 依次为占位符及其取值，如 "{position}", "3"；文案来自配置，不作为格式化字符串使用。
+func replyFor(msg *wecomEntity.WxBizMsg, key replyKey, placeholders ...string) string {
+	text := defaultReplies[key]
+	if p := profileOf(msg); p != nil {
+		if override, ok := p.Replies[string(key)]; ok {
+			text = override
+		}
+	}
+	if len(placeholders) == 0 {
+		return text
+	}
+	return strings.NewReplacer(placeholders...).Replace(text)
+}
+
+var (
+	faqMutex sync.RWMutex
+	faq      = map[string]string{}
+)
+
+// SetFAQ 设置常见问题固定回答，命中时不再调用大模型知识引擎
+func SetFAQ(answers map[string]string) {
+	normalized := make(map[string]string, len(answers))
+	for q, a := range answers {
+		normalized[strings.TrimSpace(q)] = a
+	}
+	faqMutex.Lock()
+	faq = normalized
+	faqMutex.Unlock()
+	log.Printf("FAQ updated, count: %d", len(normalized))
+}
+
+// instantAnswer 查找可立即给出的回答：内置指令或常见问题，未命中返回 false
+func instantAnswer(msg *wecomEntity.WxBizMsg) (string, bool) {
+	if answer, ok := runCommand(msg); ok {
+		return answer, true
+	}
+	faqMutex.RLock()
+	defer faqMutex.RUnlock()
+	answer, ok := faq[strings.TrimSpace(msg.Content)]
+	return answer, ok
+}
+
+// replyText 回复一条文本消息。开启被动回复时加密后直接写入回调响应，
+// 否则（或被动回复失败时）写入空响应并通过发送应用消息接口主动发送。
+func replyText(w http.ResponseWriter, p *wecomEntity.WxBizURLParam, ring *keyring.KeyRing, key keyring.KeyPair, msg *wecomEntity.WxBizMsg, content string) {
+	content = filterReply(msg, content)
+	if len(content) == 0 {
+		w.Write(nil)
+		return
+	}
+	if config.Get().PassiveReply {
+		if writePassiveReply(w, p, ring, key, msg, content) {
+			log.Printf("PassiveReply success, msgId: %d", msg.MsgId)
+			return
+		}
+	}
+	w.Write(nil)
+	go deliver(msg, content, false)
+}
+
+func writePassiveReply(w http.ResponseWriter, p *wecomEntity.WxBizURLParam, ring *keyring.KeyRing, key keyring.KeyPair, msg *wecomEntity.WxBizMsg, content string) bool {
+	reply := wecomEntity.WxBizReplyTextMsg{
+		ToUserName:   wecomEntity.CDATA{Value: msg.FromUserName},
+		FromUserName: wecomEntity.CDATA{Value: msg.ToUserName},
+		CreateTime:   time.Now().Unix(),
+		MsgType:      wecomEntity.CDATA{Value: string(wecomEntity.MsgTypeText)},
+		Content:      wecomEntity.CDATA{Value: content},
+	}
+	replyBytes, err := xml.Marshal(&reply)
+	if err != nil {
+		log.Printf("PassiveReply marshal failed, msgId: %d, err: %v", msg.MsgId, err)
+		return false
+	}
+	encrypted, cryptErr := ring.EncryptMsg(key, string(replyBytes), p.Timestamp, p.Nonce)
+	if cryptErr != nil {
+		log.Printf("PassiveReply encrypt failed, msgId: %d, err: %v", msg.MsgId, cryptErr)
+		return false
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(encrypted)
+	return true
+}
+
+// sendText 经过出站回复过滤器后，通过发送应用消息接口主动发送文本消息
+func sendText(msg *wecomEntity.WxBizMsg, content string) {
+	if content = filterReply(msg, content); len(content) != 0 {
+		deliver(msg, content, false)
+	}
+}
+
+// sendMarkdown 经过出站回复过滤器后，通过发送应用消息接口主动发送Markdown消息
+func sendMarkdown(msg *wecomEntity.WxBizMsg, content string) {
+	if content = filterReply(msg, content); len(content) != 0 {
+		deliver(msg, content, true)
+	}
+}
+
+func deliver(msg *wecomEntity.WxBizMsg, content string, markdown bool) {
+	profile := profileOf(msg)
+	if profile == nil {
+		log.Printf("SendBackMessage skipped, profile of agent %d removed, msgID: %d", msg.AgentID, msg.MsgId)
+		return
+	}
+	var wecomResp *wecomEntity.MessageResponse
+	var wecomErr error
+	if markdown {
+		wecomResp, wecomErr = profile.api.SendMarkdownMessage(int(msg.AgentID), content, msg.FromUserName)
+	} else {
+		wecomResp, wecomErr = profile.api.SendTextMessage(int(msg.AgentID), content, msg.FromUserName)
+	}
+	if wecomErr != nil {
+		log.Printf("SendBackMessage failed, msgID: %d, err: %v", msg.MsgId, wecomErr)
+		return
+	}
+	log.Printf("SendBackMessage success, msgId: %d, resp: %v", msg.MsgId, *wecomResp)
+}
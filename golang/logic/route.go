@@ -0,0 +1,219 @@
+package logic
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"example.com/play/config"
+	"example.com/play/logic/render"
+	wecomEntity "example.com/play/repo/wecom/entity"
+	"example.com/play/utils"
+)
+
+const (
+	// departmentTTL 用户所属部门的缓存时间，部门调整后最多延迟该时间生效
+	departmentTTL = time.Hour
+	// lkeSessionIdleTTL 会话的空闲时间，超过后再次提问开始新的会话
+	lkeSessionIdleTTL = 30 * time.Minute
+)
+
+var (
+	// lkeSessions 用户与各知识引擎应用的会话，进程重启后失效
+	lkeSessions = &sessionStore{items: map[string]lkeSession{}}
+	// userDepartments 用户所属部门的缓存，key 为企业ID加用户ID
+	userDepartments sync.Map
+)
+
+// lkeTarget 回答问题的大模型知识引擎应用
+type lkeTarget struct {
+	name       string
+	appKey     string
+	systemRole string
+}
+
+// lkeRoute 已编译的路由规则
+type lkeRoute struct {
+	target      lkeTarget
+	prefix      string
+	pattern     *regexp.Regexp
+	departments map[int64]bool
+}
+
+// cachedDepartments 缓存的用户所属部门
+type cachedDepartments struct {
+	ids       []int64
+	expiresAt time.Time
+}
+
+// compileRoutes 编译应用的路由规则
+func compileRoutes(routes []config.Route) ([]*lkeRoute, error) {
+	compiled := make([]*lkeRoute, 0, len(routes))
+	for _, r := range routes {
+		route := &lkeRoute{
+			target: lkeTarget{name: r.Name, appKey: r.LKEAppKey, systemRole: r.LKESystemRole},
+			prefix: r.Prefix,
+		}
+		if r.Pattern != "" {
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: invalid pattern: %v", r.Name, err)
+			}
+			route.pattern = pattern
+		}
+		if len(r.Departments) > 0 {
+			route.departments = make(map[int64]bool, len(r.Departments))
+			for _, id := range r.Departments {
+				route.departments[id] = true
+			}
+		}
+		compiled = append(compiled, route)
+	}
+	return compiled, nil
+}
+
+// defaultTarget 应用默认的知识引擎，未命中任何路由规则时使用
+func (p *appProfile) defaultTarget() lkeTarget {
+	name := p.LKEAppName
+	if name == "" {
+		name = p.Name
+	}
+	return lkeTarget{name: name, appKey: p.LKEAppKey, systemRole: p.LKESystemRole}
+}
+
+// routeQuestion 按路由规则选择回答问题的知识引擎应用，依次匹配指令前缀、关键词和用户部门，
+// 都未命中时使用应用默认的知识引擎。命中指令前缀时返回去掉前缀后的问题。
+func routeQuestion(profile *appProfile, msg *wecomEntity.WxBizMsg) (lkeTarget, string) {
+	for _, r := range profile.routes {
+		if question, ok := cutPrefix(msg.Content, r.prefix); ok {
+			return r.target, question
+		}
+	}
+	for _, r := range profile.routes {
+		if r.pattern != nil && r.pattern.MatchString(msg.Content) {
+			return r.target, msg.Content
+		}
+	}
+	var departments []int64
+	for _, r := range profile.routes {
+		if r.departments == nil {
+			continue
+		}
+		if departments == nil {
+			departments = departmentsOf(profile, msg)
+		}
+		for _, id := range departments {
+			if r.departments[id] {
+				return r.target, msg.Content
+			}
+		}
+	}
+	return profile.defaultTarget(), msg.Content
+}
+
+// cutPrefix 问题以指令前缀开头（忽略大小写，前缀后为空白或问题结束）时返回去掉前缀后的问题
+func cutPrefix(content, prefix string) (string, bool) {
+	content = strings.TrimSpace(content)
+	if prefix == "" || len(content) < len(prefix) || !strings.EqualFold(content[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := content[len(prefix):]
+	if rest != "" && !unicode.IsSpace([]rune(rest)[0]) {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// departmentsOf 返回提问用户所属的部门，读取失败时返回空列表且不缓存
+func departmentsOf(profile *appProfile, msg *wecomEntity.WxBizMsg) []int64 {
+	key := msg.ToUserName + ":" + msg.FromUserName
+	if value, ok := userDepartments.Load(key); ok {
+		if cached := value.(cachedDepartments); time.Now().Before(cached.expiresAt) {
+			return cached.ids
+		}
+	}
+	user, err := profile.api.GetUser(msg.FromUserName)
+	if err != nil {
+		log.Printf("Get user departments failed, user: %s, err: %v", msg.FromUserName, err)
+		return []int64{}
+	}
+	ids := append([]int64{}, user.Department...)
+	userDepartments.Store(key, cachedDepartments{ids: ids, expiresAt: time.Now().Add(departmentTTL)})
+	return ids
+}
+
+// lkeSession 用户与一个知识引擎应用的会话
+type lkeSession struct {
+	id       string
+	lastUsed time.Time
+}
+
+// sessionStore 用户与各知识引擎应用的会话，key 为 userKey 加 BotAppKey。
+// 空闲超过 lkeSessionIdleTTL 的会话失效，失效的会话在访问时定期清理。
+type sessionStore struct {
+	mutex  sync.Mutex
+	items  map[string]lkeSession
+	pruned time.Time
+}
+
+// get 返回会话ID并刷新空闲时间，没有会话或会话已失效时创建新的会话
+func (s *sessionStore) get(key string) string {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.pruned) > lkeSessionIdleTTL {
+		for k, session := range s.items {
+			if now.Sub(session.lastUsed) > lkeSessionIdleTTL {
+				delete(s.items, k)
+			}
+		}
+		s.pruned = now
+	}
+	session, ok := s.items[key]
+	if !ok || now.Sub(session.lastUsed) > lkeSessionIdleTTL {
+		session.id = utils.GetSessionID()
+	}
+	session.lastUsed = now
+	s.items[key] = session
+	return session.id
+}
+
+// reset 删除 key 以 prefix 开头的全部会话，返回删除的数量
+func (s *sessionStore) reset(prefix string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for k := range s.items {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.items, k)
+			n++
+		}
+	}
+	return n
+}
+
+// lkeSessionID 返回用户与知识引擎应用的会话ID，首次提问或会话空闲超过 lkeSessionIdleTTL 时创建。
+// 每个知识引擎应用使用独立的会话，切换知识库不会带入其他知识库的上下文。
+func lkeSessionID(msg *wecomEntity.WxBizMsg, appKey string) string {
+	return lkeSessions.get(userKey(msg) + ":" + appKey)
+}
+
+// handleNewCommand 结束用户在当前应用中与全部知识引擎应用的会话，之后的提问开始新的会话
+func handleNewCommand(msg *wecomEntity.WxBizMsg, args []string) string {
+	lkeSessions.reset(userKey(msg) + ":")
+	return "已开始新的对话，之后的问题不会带入之前的上下文"
+}
+
+// appendKnowledgeBase 在最后一条消息末尾注明回答问题的知识库，没有剩余消息时单独发送一条
+func appendKnowledgeBase(messages []render.Message, name string) []render.Message {
+	note := fmt.Sprintf("—— 来自「%s」", name)
+	if len(messages) == 0 {
+		return []render.Message{{Content: note}}
+	}
+	messages[len(messages)-1].Content += "\n\n" + note
+	return messages
+}
@@ -1,22 +1,49 @@
 package logic
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"time"
 
-	"example.com/play/config"
+	"example.com/play/logic/dispatcher"
+	"example.com/play/logic/render"
 	lkeClient "example.com/play/repo/tencentlke/client"
 	lkeEntity "example.com/play/repo/tencentlke/entity"
-	wecomClient "example.com/play/repo/wecom/client"
 	wecomEntity "example.com/play/repo/wecom/entity"
-	wecomCrypt "example.com/play/repo/wecom/wxbizmsgcrypt"
-	"example.com/play/utils"
+	"example.com/play/repo/wecom/keyring"
 )
 
+var (
+	// lkeChatClient 大模型知识引擎对话接口客户端
+	lkeChatClient = lkeClient.New()
+	// lkeDispatcher 调用大模型知识引擎的任务分发器，限制并发并保证同一用户的问题逐个按顺序处理
+	lkeDispatcher *dispatcher.Dispatcher
+	// lkeDebouncer 合并同一用户短时间内连续发送的多条消息，为 nil 时不合并
+	lkeDebouncer *dispatcher.Debouncer[*wecomEntity.WxBizMsg]
+)
+
+// StartDispatcher 启动调用大模型知识引擎的任务分发器，debounceWindow 大于 0 时开启消息合并
+func StartDispatcher(workers, queueSize int, debounceWindow time.Duration) {
+	lkeDispatcher = dispatcher.New(workers, queueSize)
+	if debounceWindow > 0 {
+		lkeDebouncer = dispatcher.NewDebouncer(debounceWindow, submitMergedMessages)
+	}
+	log.Printf("Dispatcher started, workers: %d, queueSize: %d, debounceWindow: %v", workers, queueSize, debounceWindow)
+}
+
+// CallbackHandler 按回调路径找到对应的企业微信应用，验证URL或接收消息
 func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	profile, instruction, ok := profileByPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		log.Printf("Get request of unknown path from %s, url: %s", r.RemoteAddr, r.URL.String())
+		return
+	}
 	// 解析并解码URL参数
 	query, err := url.QueryUnescape(r.URL.RawQuery)
 	if err != nil {
@@ -57,8 +84,12 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Println("\n---------------------------")
-		log.Printf("Received GET request from %s", r.RemoteAddr)
-		VerifyURLHandler(w, &urlParams)
+		log.Printf("Received GET request from %s, profile: %s", r.RemoteAddr, profile.Name)
+		ring := profile.keys
+		if instruction {
+			ring = profile.suite.keys
+		}
+		VerifyURLHandler(w, ring, &urlParams)
 		return
 	}
 	// 不存在EchoStr且不为Post请求，报错返回
@@ -72,100 +103,178 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			log.Printf("Request body from %s exceeds %d bytes", r.RemoteAddr, tooLarge.Limit)
+			return
+		}
 		http.Error(w, "Error reading request body", http.StatusBadRequest)
 		log.Printf("Error reading body: %v", err)
 		return
 	}
 	log.Println("---------------------------")
-	log.Printf("Received POST request from %s", r.RemoteAddr)
+	log.Printf("Received POST request from %s, profile: %s", r.RemoteAddr, profile.Name)
 	// for k, v := range r.Header {
 	// 	log.Printf("%s: %s", k, v)
 	// }
 	// log.Printf("Body (%d bytes):\n%s\n", len(body), body)
-	ReceiveMessageHandler(w, &urlParams, body)
+	if instruction {
+		SuiteInstructionHandler(w, profile, &urlParams, body)
+		return
+	}
+	ReceiveMessageHandler(w, profile, &urlParams, body)
 }
 
-func VerifyURLHandler(w http.ResponseWriter, p *wecomEntity.WxBizURLParam) {
+func VerifyURLHandler(w http.ResponseWriter, ring *keyring.KeyRing, p *wecomEntity.WxBizURLParam) {
 	// 验证URL
-	wxcpt := wecomCrypt.NewWXBizMsgCrypt(config.Config.WxToken, config.Config.WxEncodingAESKey, config.Config.WxCorpID, wecomCrypt.XmlType)
-	echoStr, cryptErr := wxcpt.VerifyURL(p.MsgSignature, p.Timestamp, p.Nonce, p.EchoStr)
+	echoStr, key, cryptErr := ring.VerifyURL(p.MsgSignature, p.Timestamp, p.Nonce, p.EchoStr)
 	if cryptErr != nil {
 		http.Error(w, "VerifyURL process failed", http.StatusUnauthorized)
 		log.Println("VerifyURL process failed, err:", cryptErr)
+		return
 	}
-	log.Println("VerifyURL process success, echoStr:", string(echoStr))
+	log.Printf("VerifyURL process success, key: %s, echoStr: %s", key.ID(), string(echoStr))
 	// 返回解密后的EchoStr
 	w.Write([]byte(echoStr))
 }
 
-func ReceiveMessageHandler(w http.ResponseWriter, p *wecomEntity.WxBizURLParam, msgBodyStr []byte) {
+func ReceiveMessageHandler(w http.ResponseWriter, profile *appProfile, p *wecomEntity.WxBizURLParam, msgBodyStr []byte) {
 	// 解密用户消息
-	wxcpt := wecomCrypt.NewWXBizMsgCrypt(config.Config.WxToken, config.Config.WxEncodingAESKey, config.Config.WxCorpID, wecomCrypt.XmlType)
-	msgStr, cryptErr := wxcpt.DecryptMsg(p.MsgSignature, p.Timestamp, p.Nonce, msgBodyStr)
+	msgStr, key, cryptErr := profile.keys.DecryptMsg(p.MsgSignature, p.Timestamp, p.Nonce, msgBodyStr)
 	if cryptErr != nil {
 		http.Error(w, "DecryptMsg process failed", http.StatusUnauthorized)
 		log.Println("DecryptMsg process failed", cryptErr)
+		return
 	}
-	log.Printf("DecryptMsg process success, msg: %s", string(msgStr))
+	log.Printf("DecryptMsg process success, key: %s, msg: %s", key.ID(), string(msgStr))
 	var msg wecomEntity.WxBizMsg
 	err := xml.Unmarshal(msgStr, &msg)
 	if err != nil {
 		http.Error(w, "ParseMsg process failed", http.StatusInternalServerError)
 		log.Println("ParseMsg process failed, err:", err)
-	}
-	log.Printf("ParseMsg process success, msg: %+v", msg)
-	// 目前仅支持文本消息对接大模型知识引擎
-	if msg.MsgType == wecomEntity.MsgTypeText {
-		// 将用户的消息传入腾讯云大模型知识引擎
-		go CallTencentLKEApp(&msg)
-		w.Write(nil)
 		return
 	}
-	// 其他消息类型返回提示
-	invoice := "抱歉，目前仅支持文本输入，请尝试用文字与我交流 :-/"
-	wecomResp, wecomErr := wecomClient.SendTextMessage(int(msg.AgentID), invoice, msg.FromUserName)
-	if wecomErr != nil {
-		log.Printf("SendBackMessage failed, msgID: %d, err: %v", msg.MsgId, wecomErr)
+	// 第三方应用的消息来自各授权企业，按企业ID找到对应的应用
+	if profile.suite != nil {
+		corp := profile.suite.corpProfile(profile, msg.ToUserName)
+		if corp == nil {
+			http.Error(w, "Corp not authorized", http.StatusForbidden)
+			log.Printf("Corp not authorized, profile: %s, corp: %s", profile.Name, msg.ToUserName)
+			return
+		}
+		profile = corp
+	}
+	// 同一企业的多个应用共用解密密钥时，防止消息被投递到其他应用的回调路径
+	if profile.WxAgentID != 0 && msg.AgentID != profile.WxAgentID {
+		http.Error(w, "AgentID mismatch", http.StatusForbidden)
+		log.Printf("AgentID mismatch, profile: %s, want: %d, got: %d", profile.Name, profile.WxAgentID, msg.AgentID)
 		return
 	}
-	log.Printf("SendBackMessage success, msgId: %d, resp: %v", msg.MsgId, *wecomResp)
+	runPipeline(&MessageContext{Msg: &msg, Params: p, Key: key, profile: profile, w: w})
 }
 
-func CallTencentLKEApp(wecomMsg *wecomEntity.WxBizMsg) {
-	sessionID := utils.GetSessionID()
+// CallTencentLKEApp 调用大模型知识引擎并将流式回答渲染后逐条发送给用户，ctx 取消时告知用户回答被中断
+func CallTencentLKEApp(ctx context.Context, wecomMsg *wecomEntity.WxBizMsg) {
+	profile := profileOf(wecomMsg)
+	if profile == nil {
+		log.Printf("Call TencentLKEApp skipped, profile of agent %d removed, msgID: %d", wecomMsg.AgentID, wecomMsg.MsgId)
+		return
+	}
+	opts, err := renderOptions(profile, wecomMsg.FromUserName)
+	if err != nil {
+		log.Printf("Create render options failed, msgID: %d, err: %v", wecomMsg.MsgId, err)
+		sendText(wecomMsg, replyFor(wecomMsg, replyLKEFailed))
+		return
+	}
+	renderer, err := render.New(profile.LKERenderer, opts)
+	if err != nil {
+		log.Printf("Create renderer failed, msgID: %d, err: %v", wecomMsg.MsgId, err)
+		sendText(wecomMsg, replyFor(wecomMsg, replyLKEFailed))
+		return
+	}
+	target, question := routeQuestion(profile, wecomMsg)
+	if question == "" {
+		sendText(wecomMsg, replyFor(wecomMsg, replyEmptyQuestion))
+		return
+	}
 	event := &lkeEntity.SseSendEvent{
-		Content:           wecomMsg.Content,
-		BotAppKey:         config.Config.TencentCloudLKEAppKey,
+		Content:           question,
+		BotAppKey:         target.appKey,
 		VisitorBizID:      wecomMsg.FromUserName,
-		SessionID:         sessionID,
+		SessionID:         lkeSessionID(wecomMsg, target.appKey),
 		StreamingThrottle: 1,
+		SystemRole:        target.systemRole,
 	}
+	log.Printf("Call TencentLKEApp, msgID: %d, knowledge base: %s", wecomMsg.MsgId, target.name)
 
-	replyChan, errChan := lkeClient.SendEvent(event)
-	for {
-		select {
-		case reply := <-replyChan:
-			log.Printf("Call TencentLKEApp, msgID: %d, markdown reply:\n%s", wecomMsg.MsgId, reply)
-			if len(reply) == 0 {
-				continue
-			}
-			wecomResp, wecomErr := wecomClient.SendMarkdownMessage(int(wecomMsg.AgentID), reply, wecomMsg.FromUserName)
-			if wecomErr != nil {
-				log.Printf("SendBackMessage failed, msgID: %d, err: %v", wecomMsg.MsgId, wecomErr)
-				continue
-			}
-			log.Printf("SendBackMessage success, msgId: %d, resp: %v", wecomMsg.MsgId, *wecomResp)
-		case err := <-errChan:
-			if err != nil {
-				invoice := "抱歉，调用大模型知识引擎出现了一点问题，请稍后再试 :-<"
-				wecomResp, wecomErr := wecomClient.SendTextMessage(int(wecomMsg.AgentID), invoice, wecomMsg.FromUserName)
-				if wecomErr != nil {
-					log.Printf("SendBackMessage failed, msgID: %d, err: %v", wecomMsg.MsgId, wecomErr)
-					return
-				}
-				log.Printf("SendBackMessage success, msgId: %d, resp: %v", wecomMsg.MsgId, *wecomResp)
-			}
+	events, err := lkeChatClient.Chat(ctx, event)
+	if err != nil {
+		lkeCallFailed(ctx, wecomMsg, err)
+		return
+	}
+	var refs []lkeEntity.Reference
+	final := false
+	for ev := range events {
+		switch ev := ev.(type) {
+		case *lkeClient.ErrorEvent:
+			lkeCallFailed(ctx, wecomMsg, ev)
 			return
+		case *lkeClient.ReferenceEvent:
+			refs = append(refs, ev.References...)
+		case *lkeClient.FinalEvent:
+			final = true
+			setLastRecord(userKey(wecomMsg), target.appKey, ev.RecordID)
+		}
+		sendRendered(wecomMsg, renderer.Render(ev))
+	}
+	if ctx.Err() != nil {
+		lkeCallFailed(ctx, wecomMsg, ctx.Err())
+		return
+	}
+	if !final {
+		// 未收到最终回复的回答不完整，不能当作完整回答发送
+		lkeCallFailed(ctx, wecomMsg, errors.New("stream closed without final event"))
+		return
+	}
+	messages := renderer.Flush()
+	if len(profile.routes) > 0 {
+		messages = appendKnowledgeBase(messages, target.name)
+	}
+	sendRendered(wecomMsg, messages)
+	setLastSources(userKey(wecomMsg), refs)
+	sendAutoSources(ctx, wecomMsg, refs)
+}
+
+// sendRendered 依次发送渲染后的消息
+func sendRendered(wecomMsg *wecomEntity.WxBizMsg, messages []render.Message) {
+	for _, m := range messages {
+		log.Printf("Call TencentLKEApp, msgID: %d, markdown: %v, reply:\n%s", wecomMsg.MsgId, m.Markdown, m.Content)
+		if m.Markdown {
+			sendMarkdown(wecomMsg, m.Content)
+		} else {
+			sendText(wecomMsg, m.Content)
 		}
 	}
 }
+
+// lkeCallFailed 调用大模型知识引擎失败时告知用户，服务退出导致的中断单独提示
+func lkeCallFailed(ctx context.Context, wecomMsg *wecomEntity.WxBizMsg, err error) {
+	if ctx.Err() != nil {
+		log.Printf("Call TencentLKEApp interrupted, msgID: %d, err: %v", wecomMsg.MsgId, err)
+		sendText(wecomMsg, replyFor(wecomMsg, replyInterrupted))
+		return
+	}
+	log.Printf("Call TencentLKEApp failed, msgID: %d, err: %v", wecomMsg.MsgId, err)
+	sendText(wecomMsg, replyFor(wecomMsg, replyLKEFailed))
+}
+
+// Shutdown 停止接收新问题：合并中的消息立即提交，等待正在回答和排队中的问题完成，
+// 超过 ctx 期限仍在排队的问题告知用户稍后重试，正在回答的问题中断并告知用户
+func Shutdown(ctx context.Context) {
+	if lkeDebouncer != nil {
+		lkeDebouncer.Flush()
+	}
+	lkeDispatcher.Close()
+	lkeDispatcher.Wait(ctx)
+}
@@ -0,0 +1,256 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/play/config"
+	"example.com/play/logic/dispatcher"
+	"example.com/play/logic/render"
+	lkeEntity "example.com/play/repo/tencentlke/entity"
+	wecomEntity "example.com/play/repo/wecom/entity"
+)
+
+const (
+	// maxSourceSize 企业微信文件类型临时素材的大小上限
+	maxSourceSize = 20 << 20
+	// sourceMediaTTL 上传的临时素材仅三天内有效，提前一小时失效重新上传
+	sourceMediaTTL = 3*24*time.Hour - time.Hour
+	// maxAutoSources 自动发送原文时每次回答最多发送的文档数
+	maxAutoSources = 3
+)
+
+var (
+	// lastSources 用户最近一次回答引用的资料，key 为 userKey，进程重启后失效
+	lastSources sync.Map
+	// sourceHTTPClient 下载引用资料原文使用的 http.Client，重定向的链接同样需要在白名单内
+	sourceHTTPClient = &http.Client{Timeout: time.Minute, CheckRedirect: checkSourceRedirect}
+	// sourceMedia 已上传的资料原文，避免重复下载和上传
+	sourceMedia = &mediaCache{items: map[string]cachedMedia{}}
+)
+
+// cachedMedia 已上传的临时素材
+type cachedMedia struct {
+	mediaID   string
+	expiresAt time.Time
+}
+
+// mediaCache 资料原文对应的临时素材缓存，key 为应用名称加 DocBizID
+type mediaCache struct {
+	mutex sync.Mutex
+	items map[string]cachedMedia
+}
+
+func (c *mediaCache) get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(c.items, key)
+		return "", false
+	}
+	return item.mediaID, true
+}
+
+func (c *mediaCache) set(key, mediaID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items[key] = cachedMedia{mediaID: mediaID, expiresAt: time.Now().Add(sourceMediaTTL)}
+}
+
+// setLastSources 记录用户最近一次回答引用的资料，没有引用时保留之前的记录
+func setLastSources(key string, refs []lkeEntity.Reference) {
+	if len(refs) == 0 {
+		return
+	}
+	lastSources.Store(key, render.MergeReferences(refs))
+}
+
+// findSource 按引用ID查找用户最近一次回答引用的资料
+func findSource(key, id string) (render.ReferenceSource, bool) {
+	value, ok := lastSources.Load(key)
+	if !ok {
+		return render.ReferenceSource{}, false
+	}
+	for _, src := range value.([]render.ReferenceSource) {
+		for _, refID := range src.IDs {
+			if refID == id {
+				return src, true
+			}
+		}
+	}
+	return render.ReferenceSource{}, false
+}
+
+func handleSourceCommand(msg *wecomEntity.WxBizMsg, args []string) string {
+	if len(args) == 0 {
+		return sourceListText(userKey(msg))
+	}
+	id := strings.Trim(args[0], "[]【】")
+	src, ok := findSource(userKey(msg), id)
+	if !ok {
+		return fmt.Sprintf("最近一次回答中没有引用【%s】，发送 /source 查看可获取的资料", id)
+	}
+	if !sourceFetchable(src) {
+		return fmt.Sprintf("【%s】%s 没有可发送的原文", id, src.Name)
+	}
+	// 与该用户的回答一起排队，保证原文在正在进行的回答之后发送
+	_, err := lkeDispatcher.Submit(&dispatcher.Task{
+		Key: userKey(msg),
+		Run: func(ctx context.Context) {
+			if err := sendSource(ctx, msg, src); err != nil {
+				log.Printf("Send source failed, msgID: %d, doc: %s, err: %v", msg.MsgId, src.Name, err)
+				sendText(msg, replyFor(msg, replySourceFailed, "{name}", src.Name))
+			}
+		},
+		Drop: func() { sendText(msg, replyFor(msg, replyShuttingDown)) },
+	})
+	if err != nil {
+		log.Printf("Submit source task failed, msgId: %d, err: %v", msg.MsgId, err)
+		if errors.Is(err, dispatcher.ErrClosed) {
+			return replyFor(msg, replyShuttingDown)
+		}
+		return replyFor(msg, replyBusy)
+	}
+	return fmt.Sprintf("正在发送《%s》原文，请稍等...", src.Name)
+}
+
+func sourceListText(key string) string {
+	value, ok := lastSources.Load(key)
+	if !ok {
+		return "最近一次回答没有引用资料"
+	}
+	var b strings.Builder
+	b.WriteString("最近一次回答引用的资料：")
+	for _, src := range value.([]render.ReferenceSource) {
+		b.WriteString("\n")
+		for _, id := range src.IDs {
+			fmt.Fprintf(&b, "【%s】", id)
+		}
+		if src.IsQA {
+			fmt.Fprintf(&b, " 问答：%s", src.Name)
+		} else {
+			fmt.Fprintf(&b, " %s", src.Name)
+		}
+	}
+	b.WriteString("\n\n发送 /source <编号> 获取文档原文")
+	return b.String()
+}
+
+// sourceFetchable 判断是否可以下载资料原文：只有知识库中的文档可以，且链接需在 lke_link_domains 白名单内。
+// 联网检索结果的链接指向任意第三方网站，问答对没有原文，都不由服务端下载。
+func sourceFetchable(src render.ReferenceSource) bool {
+	return src.IsDocument() && src.URL != "" && linkPolicy.Load().Allowed(src.URL)
+}
+
+// checkSourceRedirect 下载原文时只跟随白名单内的重定向
+func checkSourceRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !linkPolicy.Load().Allowed(req.URL.String()) {
+		return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+	}
+	return nil
+}
+
+// sendAutoSources 自动发送回答引用的文档原文，最多 maxAutoSources 份
+func sendAutoSources(ctx context.Context, msg *wecomEntity.WxBizMsg, refs []lkeEntity.Reference) {
+	if !config.Get().LKESourceAuto {
+		return
+	}
+	sent := 0
+	for _, src := range render.MergeReferences(refs) {
+		if !sourceFetchable(src) {
+			continue
+		}
+		if sent >= maxAutoSources || ctx.Err() != nil {
+			return
+		}
+		sent++
+		if err := sendSource(ctx, msg, src); err != nil {
+			log.Printf("Send source failed, msgID: %d, doc: %s, err: %v", msg.MsgId, src.Name, err)
+		}
+	}
+}
+
+// sendSource 下载资料原文并上传为临时素材，以文件消息发送给用户，已上传过的资料直接复用
+func sendSource(ctx context.Context, msg *wecomEntity.WxBizMsg, src render.ReferenceSource) error {
+	profile := profileOf(msg)
+	if profile == nil {
+		return fmt.Errorf("profile of agent %d removed", msg.AgentID)
+	}
+	// 临时素材只能由上传的应用使用
+	key := profile.Name + ":" + src.DocBizID
+	mediaID, ok := sourceMedia.get(key)
+	if !ok {
+		data, err := downloadSource(ctx, src.URL)
+		if err != nil {
+			return err
+		}
+		uploadResp, err := profile.api.UploadMedia("file", sourceFilename(src), data)
+		if err != nil {
+			return err
+		}
+		mediaID = uploadResp.MediaID
+		sourceMedia.set(key, mediaID)
+	}
+	resp, err := profile.api.SendFileMessage(int(msg.AgentID), mediaID, msg.FromUserName)
+	if err != nil {
+		return err
+	}
+	log.Printf("Send source success, msgID: %d, doc: %s, resp: %v", msg.MsgId, src.Name, *resp)
+	return nil
+}
+
+// downloadSource 下载资料原文，超过企业微信文件大小上限时返回错误
+func downloadSource(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download source: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected http status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %v", err)
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("source exceeds %d bytes", maxSourceSize)
+	}
+	return data, nil
+}
+
+// sourceFilename 以文档名作为文件名，文档名没有扩展名时使用链接中的扩展名
+func sourceFilename(src render.ReferenceSource) string {
+	name := strings.TrimSpace(src.Name)
+	if u, err := url.Parse(src.URL); err == nil {
+		if name == "" {
+			name = path.Base(u.Path)
+		} else if path.Ext(name) == "" {
+			name += path.Ext(u.Path)
+		}
+	}
+	if name == "" || name == "/" || name == "." {
+		name = "source"
+	}
+	return name
+}
@@ -0,0 +1,102 @@
+package logic
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"sync"
+
+	"example.com/play/config"
+	wecomClient "example.com/play/repo/wecom/client"
+	"example.com/play/repo/wecom/cron"
+	wecomEntity "example.com/play/repo/wecom/entity"
+	"example.com/play/repo/wecom/keyring"
+	"example.com/play/repo/wecom/suite"
+)
+
+// suiteApp 运行中的第三方应用：指令回调的密钥环，以及已收到消息的授权企业对应的应用
+type suiteApp struct {
+	suite *suite.Suite
+	// keys 指令回调的密钥环，ReceiveId 为 SuiteID
+	keys *keyring.KeyRing
+
+	mutex sync.Mutex
+	corps map[string]*appProfile
+}
+
+func newSuiteApp(p config.Profile, tokens *cron.Manager) (*suiteApp, error) {
+	store, err := suite.NewFileStore(p.WxSuiteStore, p.WxSuiteID)
+	if err != nil {
+		return nil, err
+	}
+	return &suiteApp{
+		suite: suite.New(p.WxSuiteID, p.WxSuiteSecret, store, tokens),
+		keys:  keyring.New(p.WxSuiteID, p.CryptKeys),
+		corps: map[string]*appProfile{},
+	}, nil
+}
+
+// corpProfile 返回授权企业使用第三方应用 p 时对应的应用，企业未安装该第三方应用时返回 nil。
+// 授权企业的应用沿用第三方应用的知识引擎配置，使用企业的 access token 发送消息。
+func (a *suiteApp) corpProfile(p *appProfile, corpID string) *appProfile {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if corp, ok := a.corps[corpID]; ok {
+		return corp
+	}
+	auth, ok, err := a.suite.Auth(corpID)
+	if err != nil {
+		log.Printf("Load suite auth failed, suite: %s, corp: %s, err: %v", a.suite.ID(), corpID, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	corp := *p
+	corp.Name = p.Name + "/" + corpID
+	corp.WxCorpID = corpID
+	corp.WxAgentID = auth.AgentID
+	// 被动回复加密时 ReceiveId 为授权企业的企业ID
+	corp.keys = keyring.New(corpID, p.CryptKeys)
+	corp.tokens = a.suite.CorpToken(corpID)
+	corp.api = wecomClient.New(corp.tokens)
+	corp.suite = nil
+	a.corps[corpID] = &corp
+	return &corp
+}
+
+// forget 授权变更或取消后丢弃企业对应的应用，下次收到消息时按新的授权信息创建
+func (a *suiteApp) forget(corpID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.corps, corpID)
+}
+
+// SuiteInstructionHandler 处理第三方应用的指令回调：suite_ticket 推送和企业授权的安装、变更、取消。
+// 处理成功时按要求返回 success，失败时返回错误状态码由企业微信重试。
+func SuiteInstructionHandler(w http.ResponseWriter, profile *appProfile, p *wecomEntity.WxBizURLParam, body []byte) {
+	data, key, cryptErr := profile.suite.keys.DecryptMsg(p.MsgSignature, p.Timestamp, p.Nonce, body)
+	if cryptErr != nil {
+		http.Error(w, "DecryptMsg process failed", http.StatusUnauthorized)
+		log.Println("Decrypt suite instruction failed", cryptErr)
+		return
+	}
+	var ins suite.Instruction
+	if err := xml.Unmarshal(data, &ins); err != nil {
+		http.Error(w, "ParseMsg process failed", http.StatusBadRequest)
+		log.Println("Parse suite instruction failed, err:", err)
+		return
+	}
+	// 指令中的 suite_ticket 和临时授权码不能输出到日志
+	log.Printf("Received suite instruction, profile: %s, key: %s, infoType: %s, authCorpId: %s",
+		profile.Name, key.ID(), ins.InfoType, ins.AuthCorpID)
+	if err := profile.suite.suite.HandleInstruction(&ins); err != nil {
+		http.Error(w, "Handle instruction failed", http.StatusInternalServerError)
+		log.Printf("Handle suite instruction failed, infoType: %s, err: %v", ins.InfoType, err)
+		return
+	}
+	if ins.InfoType == suite.InfoTypeChangeAuth || ins.InfoType == suite.InfoTypeCancelAuth {
+		profile.suite.forget(ins.AuthCorpID)
+	}
+	w.Write([]byte("success"))
+}
@@ -1,18 +1,157 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"example.com/play/config"
 	"example.com/play/logic"
+	"example.com/play/logic/render"
+	"example.com/play/repo/tencentlke/capi"
 	"example.com/play/repo/wecom/cron"
+	"example.com/play/utils/redact"
 )
 
+// watchInterval 检查配置文件和 TLS 证书文件是否修改的间隔
+const watchInterval = 5 * time.Second
+
 func main() {
+	// 所有日志在输出前遮盖已登记的密钥和 URL 中的凭证
+	log.SetOutput(redact.Writer(os.Stderr))
 	config.Init()
-	cron.StartTokenRefresher(config.Config.WxCorpID, config.Config.WxAppSecret)
-	http.HandleFunc("/", logic.CallbackHandler)
-	log.Println("Server started on :80")
-	log.Fatal(http.ListenAndServe(":80", nil))
+	cfg := config.Get()
+	tokenCache, err := cron.NewCache(cfg.WxTokenCache)
+	if err != nil {
+		log.Fatalf("Invalid token cache, err: %v", err)
+	}
+	var tokenOpts []cron.ManagerOption
+	if tokenCache != nil {
+		tokenOpts = append(tokenOpts, cron.WithCache(tokenCache))
+	}
+	tokens := cron.NewManager(tokenOpts...)
+	if err := applyConfig(cfg, tokens); err != nil {
+		log.Fatal(err)
+	}
+	logic.PrefetchTokens()
+	logic.StartDispatcher(cfg.LKEWorkers, cfg.LKEQueueSize, time.Duration(cfg.DebounceSeconds)*time.Second)
+	go reloadConfig(tokens)
+	servers, err := startServers(cfg)
+	if err != nil {
+		log.Fatalf("Start server failed, err: %v", err)
+	}
+
+	waitForShutdown(servers, tokens)
+}
+
+// applyConfig 加载配置引用的常见问题、引用模板和链接策略，与各应用的配置一起校验，全部通过后才生效，
+// 有误时返回包含全部错误的 error 并保留原有配置
+func applyConfig(cfg *config.GlobalConfig, tokens *cron.Manager) error {
+	var problems []string
+	faq, err := cfg.LoadFAQ()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", cfg.Source("faq_file"), err))
+	}
+	style, err := loadReferenceStyle(cfg)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", cfg.Source("lke_reference_template"), err))
+	}
+	policy, err := render.NewLinkPolicy(cfg.LKELinkStrip, cfg.LKELinkRewrite, strings.Split(cfg.LKELinkDomains, ","))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid reference link policy: %v", err))
+	}
+	problems = append(problems, logic.ValidateProfiles(cfg.Profiles)...)
+	if len(problems) > 0 {
+		return config.JoinProblems(problems)
+	}
+	if err := logic.SetProfiles(cfg.Profiles, tokens); err != nil {
+		return err
+	}
+
+	config.Set(cfg)
+	logic.SetFAQ(faq)
+	logic.SetReferenceStyle(style)
+	logic.SetLinkPolicy(policy)
+	if cfg.TencentCloudSecretID != "" && cfg.TencentCloudSecretKey != "" {
+		var opts []capi.Option
+		if cfg.TencentCloudRegion != "" {
+			opts = append(opts, capi.WithRegion(cfg.TencentCloudRegion))
+		}
+		logic.SetCapiClient(capi.New(cfg.TencentCloudSecretID, cfg.TencentCloudSecretKey, opts...))
+	} else {
+		logic.SetCapiClient(nil)
+	}
+	return nil
+}
+
+// waitForShutdown 收到 SIGINT/SIGTERM 后优雅退出：先停止接收回调，再等待正在进行和排队中的回答完成，
+// 超过 ShutdownTimeout 仍在排队的问题告知用户稍后重试，未完成的回答会被中断并告知用户，最后停止 access token 刷新
+func waitForShutdown(servers []*http.Server, tokens *cron.Manager) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigChan
+	log.Printf("Received signal %v, shutting down...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Get().ShutdownTimeout)*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown failed, err: %v", err)
+		}
+	}
+	logic.Shutdown(ctx)
+	tokens.Stop()
+	log.Println("Server exited")
+}
+
+// reloadConfig 收到 SIGHUP、配置文件修改或管理接口的重载请求后重新加载配置，见 reload
+func reloadConfig(tokens *cron.Manager) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	changes := config.Watch(watchInterval)
+	for {
+		select {
+		case <-sigChan:
+			reload(tokens)
+		case <-changes:
+			log.Println("Config file changed, reloading")
+			reload(tokens)
+		case done := <-reloadRequests:
+			done <- reload(tokens)
+		}
+	}
+}
+
+// reload 重新加载全部配置（含应用的回调密钥、常见问题和引用展示模板），加载失败则保留原有配置。
+// 正在进行的回答和排队中的问题不受影响，需要重启才能生效的配置项保留原值。
+func reload(tokens *cron.Manager) error {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Reload config failed, keep previous config, err: %v", err)
+		return err
+	}
+	if changed := cfg.RetainRestartSettings(config.Get()); len(changed) > 0 {
+		log.Printf("Settings %v changed, restart to take effect", changed)
+	}
+	if err := applyConfig(cfg, tokens); err != nil {
+		log.Printf("Reload config failed, keep previous config, err: %v", err)
+		return err
+	}
+	log.Println("Config reloaded")
+	return nil
+}
+
+// loadReferenceStyle 读取并解析引用的展示模板，未配置时返回 nil 使用默认模板
+func loadReferenceStyle(cfg *config.GlobalConfig) (*render.ReferenceStyle, error) {
+	text, err := cfg.LoadReferenceTemplate()
+	if err != nil || text == "" {
+		return nil, err
+	}
+	return render.ParseReferenceStyle(text)
 }
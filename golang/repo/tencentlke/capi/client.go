@@ -0,0 +1,166 @@
+package capi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/play/repo/tencentlke/entity"
+)
+
+const (
+	// service 大模型知识引擎云API的服务名
+	service = "lke"
+	// version 大模型知识引擎云API的版本
+	version     = "2023-11-30"
+	contentType = "application/json; charset=utf-8"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// Client 腾讯云API 3.0 客户端，使用 TC3-HMAC-SHA256 签名调用大模型知识引擎的管理接口，可安全地并发使用
+type Client struct {
+	secretID   string
+	secretKey  string
+	endpoint   string
+	region     string
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// Option 客户端配置项
+type Option func(*Client)
+
+// WithEndpoint 设置接入域名，默认为 entity.TencentLKECapiEndpoint
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) { c.endpoint = endpoint }
+}
+
+// WithRegion 设置地域，默认为 entity.TencentLKECapiRegion
+func WithRegion(region string) Option {
+	return func(c *Client) { c.region = region }
+}
+
+// WithHTTPClient 设置发起请求使用的 http.Client，默认超时 30 秒
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// New 使用腾讯云 API 密钥创建客户端
+func New(secretID, secretKey string, opts ...Option) *Client {
+	c := &Client{
+		secretID:   secretID,
+		secretKey:  secretKey,
+		endpoint:   entity.TencentLKECapiEndpoint,
+		region:     entity.TencentLKECapiRegion,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Call 调用云API接口 action，req 序列化为请求体，响应中的 Response 字段反序列化到 resp。
+// 接口返回错误时返回 *Error。
+func (c *Client) Call(ctx context.Context, action string, req interface{}, resp interface{}) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	timestamp := c.now().Unix()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+c.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("X-TC-Action", action)
+	httpReq.Header.Set("X-TC-Version", version)
+	httpReq.Header.Set("X-TC-Timestamp", strconv.FormatInt(timestamp, 10))
+	httpReq.Header.Set("X-TC-Region", c.region)
+	httpReq.Header.Set("Authorization", signRequest(c.secretID, c.secretKey, service, c.endpoint, action, contentType, payload, timestamp))
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected http status: %d", httpResp.StatusCode)
+	}
+
+	// 先解析公共字段判断是否出错，再解析业务字段
+	var envelope struct {
+		Response struct {
+			Error *struct {
+				Code    string `json:"Code"`
+				Message string `json:"Message"`
+			} `json:"Error"`
+			RequestID string `json:"RequestId"`
+		} `json:"Response"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if e := envelope.Response.Error; e != nil {
+		return &Error{Action: action, Code: e.Code, Message: e.Message, RequestID: envelope.Response.RequestID}
+	}
+	if resp == nil {
+		return nil
+	}
+	wrapper := struct {
+		Response interface{} `json:"Response"`
+	}{Response: resp}
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+// GetMsgRecord 获取聊天记录
+func (c *Client) GetMsgRecord(ctx context.Context, req *GetMsgRecordRequest) (*GetMsgRecordResponse, error) {
+	resp := &GetMsgRecordResponse{}
+	if err := c.Call(ctx, "GetMsgRecord", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RateMsgRecord 对回答点赞或点踩
+func (c *Client) RateMsgRecord(ctx context.Context, req *RateMsgRecordRequest) (*RateMsgRecordResponse, error) {
+	resp := &RateMsgRecordResponse{}
+	if err := c.Call(ctx, "RateMsgRecord", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListDoc 查询知识库文档列表
+func (c *Client) ListDoc(ctx context.Context, req *ListDocRequest) (*ListDocResponse, error) {
+	resp := &ListDocResponse{}
+	if err := c.Call(ctx, "ListDoc", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DeleteDoc 删除知识库文档
+func (c *Client) DeleteDoc(ctx context.Context, req *DeleteDocRequest) (*DeleteDocResponse, error) {
+	resp := &DeleteDocResponse{}
+	if err := c.Call(ctx, "DeleteDoc", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+package capi
+
+// 聊天记录的来源场景
+const (
+	MsgRecordTypeAPIVisitor = 5 // API 访客，对话接口使用 bot_app_key 发起的对话
+)
+
+// 评价分数
+const (
+	ScoreLike    uint64 = 1 // 点赞
+	ScoreDislike uint64 = 2 // 点踩
+)
+
+// GetMsgRecordRequest 获取聊天记录请求
+type GetMsgRecordRequest struct {
+	Type         int    `json:"Type"`
+	Count        int    `json:"Count"`
+	SessionID    string `json:"SessionId"`
+	LastRecordID string `json:"LastRecordId,omitempty"` // 从该记录往前获取，为空时获取最新的记录
+	BotAppKey    string `json:"BotAppKey"`
+	Scene        int    `json:"Scene,omitempty"` // 1 评测 2 正式
+}
+
+// GetMsgRecordResponse 获取聊天记录响应
+type GetMsgRecordResponse struct {
+	Records   []MsgRecord `json:"Records"`
+	RequestID string      `json:"RequestId"`
+}
+
+// MsgRecord 聊天记录
+type MsgRecord struct {
+	Content         string               `json:"Content"`
+	SessionID       string               `json:"SessionId"`
+	RecordID        string               `json:"RecordId"`
+	RelatedRecordID string               `json:"RelatedRecordId"`
+	IsFromSelf      bool                 `json:"IsFromSelf"`
+	FromName        string               `json:"FromName"`
+	Timestamp       string               `json:"Timestamp"`
+	Score           uint64               `json:"Score"`
+	CanRating       bool                 `json:"CanRating"`
+	Type            int                  `json:"Type"`
+	References      []MsgRecordReference `json:"References"`
+	Reasons         []string             `json:"Reasons"`
+	IsLlmGenerated  bool                 `json:"IsLlmGenerated"`
+	ReplyMethod     int                  `json:"ReplyMethod"`
+	TraceID         string               `json:"TraceId"`
+}
+
+// MsgRecordReference 聊天记录中的引用来源
+type MsgRecordReference struct {
+	ID    string `json:"Id"`
+	URL   string `json:"Url"`
+	Type  int    `json:"Type"`
+	Name  string `json:"Name"`
+	DocID string `json:"DocId"`
+}
+
+// RateMsgRecordRequest 评价回答请求
+type RateMsgRecordRequest struct {
+	BotAppKey string   `json:"BotAppKey"`
+	RecordID  string   `json:"RecordId"`
+	Score     uint64   `json:"Score"`
+	Reasons   []string `json:"Reasons,omitempty"` // 点踩原因
+}
+
+// RateMsgRecordResponse 评价回答响应
+type RateMsgRecordResponse struct {
+	RequestID string `json:"RequestId"`
+}
+
+// ListDocRequest 查询文档列表请求
+type ListDocRequest struct {
+	BotBizID   string `json:"BotBizId"`
+	PageNumber uint64 `json:"PageNumber"`
+	PageSize   uint64 `json:"PageSize"`
+	Query      string `json:"Query,omitempty"`
+	Status     []int  `json:"Status,omitempty"`
+}
+
+// ListDocResponse 查询文档列表响应
+type ListDocResponse struct {
+	Total     string        `json:"Total"`
+	List      []ListDocItem `json:"List"`
+	RequestID string        `json:"RequestId"`
+}
+
+// ListDocItem 文档信息
+type ListDocItem struct {
+	DocBizID   string `json:"DocBizId"`
+	FileName   string `json:"FileName"`
+	CosURL     string `json:"CosUrl"`
+	UpdateTime string `json:"UpdateTime"`
+	Status     int    `json:"Status"`
+	StatusDesc string `json:"StatusDesc"`
+	FileType   string `json:"FileType"`
+	FileSize   string `json:"FileSize"`
+}
+
+// DeleteDocRequest 删除文档请求
+type DeleteDocRequest struct {
+	BotBizID  string   `json:"BotBizId"`
+	DocBizIDs []string `json:"DocBizIds"`
+}
+
+// DeleteDocResponse 删除文档响应
+type DeleteDocResponse struct {
+	RequestID string `json:"RequestId"`
+}
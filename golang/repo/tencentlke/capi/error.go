@@ -0,0 +1,58 @@
+package capi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 常见错误码类别，可通过 errors.Is 判断 *Error 属于哪一类
+var (
+	ErrAuthFailure        = errors.New("authentication failed")           // AuthFailure.*：密钥或签名错误、签名过期等
+	ErrUnauthorized       = errors.New("unauthorized operation")          // UnauthorizedOperation.*：没有接口权限
+	ErrInvalidParameter   = errors.New("invalid parameter")               // InvalidParameter*、MissingParameter、UnknownParameter
+	ErrResourceNotFound   = errors.New("resource not found")              // ResourceNotFound.*
+	ErrLimitExceeded      = errors.New("request limit exceeded")          // RequestLimitExceeded*、LimitExceeded.*
+	ErrServiceUnavailable = errors.New("service temporarily unavailable") // InternalError*、ResourceUnavailable.*、FailedOperation.*
+)
+
+// errorClasses 错误码前缀与错误类别的对应关系
+var errorClasses = []struct {
+	prefix string
+	err    error
+}{
+	{"AuthFailure", ErrAuthFailure},
+	{"UnauthorizedOperation", ErrUnauthorized},
+	{"InvalidParameter", ErrInvalidParameter},
+	{"MissingParameter", ErrInvalidParameter},
+	{"UnknownParameter", ErrInvalidParameter},
+	{"ResourceNotFound", ErrResourceNotFound},
+	{"RequestLimitExceeded", ErrLimitExceeded},
+	{"LimitExceeded", ErrLimitExceeded},
+	{"InternalError", ErrServiceUnavailable},
+	{"ResourceUnavailable", ErrServiceUnavailable},
+	{"FailedOperation", ErrServiceUnavailable},
+}
+
+// Error 云API返回的错误
+type Error struct {
+	Action    string
+	Code      string
+	Message   string
+	RequestID string
+}
+
+// Error 实现 error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed, code: %s, message: %s, requestId: %s", e.Action, e.Code, e.Message, e.RequestID)
+}
+
+// Unwrap 返回错误码对应的错误类别，未知错误码返回 nil
+func (e *Error) Unwrap() error {
+	for _, class := range errorClasses {
+		if strings.HasPrefix(e.Code, class.prefix) {
+			return class.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package capi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm = "TC3-HMAC-SHA256"
+	// signedHeaders 参与签名的头部，需按字母序排列且为小写
+	signedHeaders = "content-type;host;x-tc-action"
+)
+
+// signature 签名过程中的各步结果
+type signature struct {
+	canonicalRequest string
+	stringToSign     string
+	signature        string
+	authorization    string
+}
+
+// signRequest 按 TC3-HMAC-SHA256 计算 POST JSON 请求的 Authorization 头，
+// 参考腾讯云 API 3.0 的签名方法 v3
+func signRequest(secretID, secretKey, service, host, action, contentType string, payload []byte, timestamp int64) string {
+	return sign(secretID, secretKey, service, host, action, contentType, payload, timestamp).authorization
+}
+
+// sign 计算签名并返回各步结果
+func sign(secretID, secretKey, service, host, action, contentType string, payload []byte, timestamp int64) signature {
+	var s signature
+	// 1. 拼接规范请求串
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-tc-action:%s\n",
+		contentType, host, strings.ToLower(action))
+	s.canonicalRequest = strings.Join([]string{
+		"POST",
+		"/",
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(payload),
+	}, "\n")
+
+	// 2. 拼接待签名字符串
+	date := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+	credentialScope := fmt.Sprintf("%s/%s/tc3_request", date, service)
+	s.stringToSign = strings.Join([]string{
+		signAlgorithm,
+		fmt.Sprintf("%d", timestamp),
+		credentialScope,
+		sha256Hex([]byte(s.canonicalRequest)),
+	}, "\n")
+
+	// 3. 计算签名
+	secretDate := hmacSHA256([]byte("TC3"+secretKey), date)
+	secretService := hmacSHA256(secretDate, service)
+	secretSigning := hmacSHA256(secretService, "tc3_request")
+	s.signature = hex.EncodeToString(hmacSHA256(secretSigning, s.stringToSign))
+
+	// 4. 拼接 Authorization
+	s.authorization = fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, secretID, credentialScope, signedHeaders, s.signature)
+	return s
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,51 @@
+package capi
+
+import "testing"
+
+// TestSign 复现腾讯云 API 3.0 签名方法 v3 文档中的示例：
+// 云服务器 DescribeInstances 接口，时间戳 1551113065（2019-02-25），请求体中的中文按文档转义为 \uXXXX
+func TestSign(t *testing.T) {
+	const (
+		secretID  = "AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******"
+		secretKey = "Gu5t9xGARNpq86cd98joQYCN3*******"
+		payload   = `{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`
+	)
+	got := sign(secretID, secretKey, "cvm", "cvm.tencentcloudapi.com", "DescribeInstances",
+		"application/json; charset=utf-8", []byte(payload), 1551113065)
+
+	want := signature{
+		canonicalRequest: "POST\n" +
+			"/\n" +
+			"\n" +
+			"content-type:application/json; charset=utf-8\n" +
+			"host:cvm.tencentcloudapi.com\n" +
+			"x-tc-action:describeinstances\n" +
+			"\n" +
+			"content-type;host;x-tc-action\n" +
+			"35e9c5b0e3ae67532d3c9f17ead6c90222632e5b1ff7f6e89887f1398934f064",
+		stringToSign: "TC3-HMAC-SHA256\n" +
+			"1551113065\n" +
+			"2019-02-25/cvm/tc3_request\n" +
+			"7019a55be8395899b900fb5564e4200d984910f34794a27cb3fb7d10ff6a1e84",
+		signature: "be4f67d323c78ab9acb7395e43c0dbcf822a9cfac32fea2449a7bc7726b770a3",
+		authorization: "TC3-HMAC-SHA256 Credential=AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******/2019-02-25/cvm/tc3_request, " +
+			"SignedHeaders=content-type;host;x-tc-action, " +
+			"Signature=be4f67d323c78ab9acb7395e43c0dbcf822a9cfac32fea2449a7bc7726b770a3",
+	}
+	if got.canonicalRequest != want.canonicalRequest {
+		t.Errorf("canonical request:\n%s\nwant:\n%s", got.canonicalRequest, want.canonicalRequest)
+	}
+	if got.stringToSign != want.stringToSign {
+		t.Errorf("string to sign:\n%s\nwant:\n%s", got.stringToSign, want.stringToSign)
+	}
+	if got.signature != want.signature {
+		t.Errorf("signature: %s, want: %s", got.signature, want.signature)
+	}
+	if got.authorization != want.authorization {
+		t.Errorf("authorization:\n%s\nwant:\n%s", got.authorization, want.authorization)
+	}
+	if auth := signRequest(secretID, secretKey, "cvm", "cvm.tencentcloudapi.com", "DescribeInstances",
+		"application/json; charset=utf-8", []byte(payload), 1551113065); auth != want.authorization {
+		t.Errorf("signRequest: %s, want: %s", auth, want.authorization)
+	}
+}
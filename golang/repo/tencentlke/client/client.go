@@ -0,0 +1,260 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/play/repo/tencentlke/entity"
+	"example.com/play/utils/sse"
+)
+
+const (
+	defaultTimeout = 10 * time.Minute
+	// eventBuffer 读取流时最多缓冲的普通事件数
+	eventBuffer = 10
+)
+
+// Client 大模型知识引擎对话接口客户端，可安全地并发使用
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	timeout      time.Duration
+	logger       *log.Logger
+	maxEventSize int
+}
+
+// Option 客户端配置项
+type Option func(*Client)
+
+// WithBaseURL 设置对话接口地址，默认为 entity.TencentLKESSEUrl
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.baseURL = baseURL }
+}
+
+// WithHTTPClient 设置发起请求使用的 http.Client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithTimeout 设置单次对话的最长时间，默认 10 分钟
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.timeout = timeout }
+}
+
+// WithLogger 设置日志输出，默认为 log.Default()
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// WithMaxEventSize 设置单个SSE事件的最大字节数，默认为 sse.DefaultMaxEventSize
+func WithMaxEventSize(n int) Option {
+	return func(c *Client) { c.maxEventSize = n }
+}
+
+// New 创建对话接口客户端
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:      entity.TencentLKESSEUrl,
+		httpClient:   &http.Client{},
+		timeout:      defaultTimeout,
+		logger:       log.Default(),
+		maxEventSize: sse.DefaultMaxEventSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Chat 发起一次对话，返回按顺序到达的类型化事件，对话结束或出错后关闭。
+// 请求无法发出或接口返回非 200 时直接返回错误；流式过程中的错误（包括超时和未收到 *FinalEvent 就结束的流）
+// 以 *ErrorEvent 作为最后一个事件返回，因此未收到 *FinalEvent 和 *ErrorEvent 就关闭的通道只可能是 ctx 被取消。
+// 调用方应持续读取直到事件通道关闭，或取消 ctx 提前结束。
+func (c *Client) Chat(ctx context.Context, req *entity.SseSendEvent) (<-chan Event, error) {
+	payloadBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to do request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("unexpected http status: %d", resp.StatusCode)
+	}
+
+	// 读取流的协程最多缓冲 eventBuffer 个普通事件，再保留一个位置给结束时的错误事件，
+	// 错误事件因此总能不阻塞地放入，不会因 c.timeout 到期与 ctx.Done() 竞争而丢失
+	stream := make(chan Event, eventBuffer+1)
+	room := make(chan struct{}, eventBuffer)
+	events := make(chan Event)
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		defer close(stream)
+		c.readStream(ctx, resp, stream, room)
+	}()
+	go relay(parent, stream, room, events)
+	return events, nil
+}
+
+// relay 将 stream 中的事件依次转交给调用方，每转交一个普通事件归还一个 room。
+// 调用方取消 ctx 后不再转交。
+func relay(ctx context.Context, stream <-chan Event, room <-chan struct{}, events chan<- Event) {
+	defer close(events)
+	for ev := range stream {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+			return
+		}
+		if _, ok := ev.(*ErrorEvent); !ok {
+			<-room
+		}
+	}
+}
+
+// readStream 读取并转换流式事件。普通事件需先占用一个 room，结束时的错误事件直接放入 stream 中保留的位置。
+func (c *Client) readStream(ctx context.Context, resp *http.Response, stream chan<- Event, room chan<- struct{}) {
+	emit := func(ev Event) bool {
+		select {
+		case room <- struct{}{}:
+			stream <- ev
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	state := &streamState{}
+	decoder := sse.NewDecoder(resp.Body, sse.WithMaxEventSize(c.maxEventSize))
+	for {
+		sseEvent, err := decoder.Decode()
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if errors.Is(err, io.EOF) {
+				if !state.final {
+					stream <- &ErrorEvent{Message: "stream ended before final event", Err: io.ErrUnexpectedEOF}
+				}
+				return
+			}
+			stream <- &ErrorEvent{Message: fmt.Sprintf("failed to read stream: %v", err), Err: err}
+			return
+		}
+		switch sseEvent.Type {
+		case entity.EventTypeTokenStat, entity.EventTypeError, entity.EventTypeReply, entity.EventTypeReference, entity.EventTypeThought,
+			"message":
+		default:
+			// 如果有新的事件类型，提示适配
+			c.logger.Println("Inspect event:", sseEvent.Type)
+		}
+		c.logger.Printf("Recv event data:\n%+v", sseEvent.Data)
+		recv := &entity.SseRecvEvent{}
+		if err := json.Unmarshal([]byte(sseEvent.Data), recv); err != nil {
+			stream <- &ErrorEvent{Message: fmt.Sprintf("failed to unmarshal event: %v", err), Err: err}
+			return
+		}
+		for _, ev := range state.convert(recv, c.logger) {
+			if errEv, ok := ev.(*ErrorEvent); ok {
+				stream <- errEv
+				return
+			}
+			if !emit(ev) {
+				stream <- &ErrorEvent{Message: fmt.Sprintf("failed to read stream: %v", ctx.Err()), Err: ctx.Err()}
+				return
+			}
+		}
+	}
+}
+
+// streamState 记录流式回复的累积内容，用于计算增量
+type streamState struct {
+	content string
+	thought string
+	final   bool // 已收到最终回复
+}
+
+// convert 将接口返回的原始事件转换为类型化事件
+func (s *streamState) convert(recv *entity.SseRecvEvent, logger *log.Logger) []Event {
+	payload := recv.Payload
+	switch recv.Type {
+	case entity.EventTypeTokenStat:
+		return []Event{&TokenStatEvent{Procedures: payload.Procedures, Raw: recv}}
+	case entity.EventTypeError:
+		logger.Printf("Get error from response: %+v", *recv)
+		return []Event{&ErrorEvent{Code: recv.Error.Code, Message: recv.Error.Message}}
+	case entity.EventTypeReference:
+		return []Event{&ReferenceEvent{References: payload.References, Raw: recv}}
+	case entity.EventTypeThought:
+		if len(payload.Procedures) == 0 {
+			return nil
+		}
+		procedure := payload.Procedures[0]
+		ev := &ThoughtEvent{
+			Title:   strings.TrimSpace(procedure.Title),
+			Content: procedure.Debugging.Content,
+			Delta:   delta(s.thought, procedure.Debugging.Content),
+			Elapsed: time.Duration(procedure.Elapsed) * time.Millisecond,
+			Raw:     recv,
+		}
+		s.thought = procedure.Debugging.Content
+		return []Event{ev}
+	case entity.EventTypeReply:
+		if payload.IsFromSelf {
+			logger.Printf("Get input event, traceId: %s, data: %+v", payload.TraceId, *recv)
+			return nil
+		}
+		events := []Event{&ReplyDeltaEvent{
+			Content: payload.Content,
+			Delta:   delta(s.content, payload.Content),
+			Raw:     recv,
+		}}
+		s.content = payload.Content
+		if payload.IsFinal {
+			s.final = true
+			logger.Printf("Get final event, traceId: %s, data: %+v", payload.TraceId, *recv)
+			events = append(events, &FinalEvent{
+				Content:     payload.Content,
+				TraceID:     payload.TraceId,
+				RecordID:    payload.RecordID,
+				ReplyMethod: payload.ReplyMethod,
+				Raw:         recv,
+			})
+		}
+		return events
+	default:
+		logger.Println("Inspect event:", *recv)
+		return nil
+	}
+}
+
+// delta 返回 current 相对 previous 新增的部分，current 不以 previous 为前缀时返回全部
+func delta(previous, current string) string {
+	if cut, ok := strings.CutPrefix(current, previous); ok {
+		return cut
+	}
+	return current
+}
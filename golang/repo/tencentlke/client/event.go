@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/play/repo/tencentlke/entity"
+)
+
+// Event 对话过程中的类型化事件，取值为以下类型之一：
+// *ReplyDeltaEvent、*ThoughtEvent、*ReferenceEvent、*TokenStatEvent、*ErrorEvent、*FinalEvent
+type Event interface {
+	isEvent()
+}
+
+// ReplyDeltaEvent 回复内容更新
+type ReplyDeltaEvent struct {
+	Content string // 截至目前的完整回复
+	Delta   string // 相比上一次回复新增的内容
+	Raw     *entity.SseRecvEvent
+}
+
+// ThoughtEvent 思考过程更新，推理模型独有
+type ThoughtEvent struct {
+	Title   string        // 思考步骤名称
+	Content string        // 截至目前的完整思考内容
+	Delta   string        // 相比上一次思考新增的内容
+	Elapsed time.Duration // 思考耗时
+	Raw     *entity.SseRecvEvent
+}
+
+// ReferenceEvent 回复引用的资料
+type ReferenceEvent struct {
+	References []entity.Reference
+	Raw        *entity.SseRecvEvent
+}
+
+// TokenStatEvent token统计，包含当前执行的过程步骤
+type TokenStatEvent struct {
+	Procedures []entity.Procedure
+	Raw        *entity.SseRecvEvent
+}
+
+// ErrorEvent 对话出错，之后不会再有其他事件
+type ErrorEvent struct {
+	Code    int // 接口返回的错误码，读取流出错时为 0
+	Message string
+	Err     error // 读取流出错时的原始错误
+}
+
+// Error 实现 error 接口
+func (e *ErrorEvent) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("lke error %d: %s", e.Code, e.Message)
+	}
+	return e.Message
+}
+
+// Unwrap 返回原始错误
+func (e *ErrorEvent) Unwrap() error {
+	return e.Err
+}
+
+// FinalEvent 回复结束，紧跟在最后一个 ReplyDeltaEvent 之后
+type FinalEvent struct {
+	Content     string
+	TraceID     string
+	RecordID    string
+	ReplyMethod entity.ReplyMethod
+	Raw         *entity.SseRecvEvent
+}
+
+func (*ReplyDeltaEvent) isEvent() {}
+func (*ThoughtEvent) isEvent()    {}
+func (*ReferenceEvent) isEvent()  {}
+func (*TokenStatEvent) isEvent()  {}
+func (*ErrorEvent) isEvent()      {}
+func (*FinalEvent) isEvent()      {}
@@ -113,6 +113,13 @@ type Reference struct {
 	URL      string `json:"url"`
 }
 
+// 引用来源类型
+const (
+	ReferenceTypeQA     = 1 // 问答
+	ReferenceTypeDoc    = 2 // 文档片段
+	ReferenceTypeSearch = 4 // 联网检索
+)
+
 // Procedure 过程步骤
 type Procedure struct {
 	Debugging    Debugging `json:"debugging"`
@@ -6,14 +6,35 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"time"
 
 	"example.com/play/repo/wecom/cron"
 	"example.com/play/repo/wecom/entity"
+	"example.com/play/utils/redact"
 )
 
+// TokenSource provides the access token of one WeCom app, implemented by *cron.Source
+type TokenSource interface {
+	Token() (string, error)
+	ForceRefresh() error
+}
+
+// Client calls the WeChat Work APIs of one app with tokens from its TokenSource, safe for concurrent use
+type Client struct {
+	tokens     TokenSource
+	httpClient *http.Client
+}
+
+// New creates a client using tokens for authentication
+func New(tokens TokenSource) *Client {
+	return &Client{tokens: tokens, httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
 // SendTextMessage sends a text message using the WeChat Work API
-func SendTextMessage(agentID int, content string, userID string) (*entity.MessageResponse, error) {
+func (c *Client) SendTextMessage(agentID int, content string, userID string) (*entity.MessageResponse, error) {
 	msg := entity.TextMessage{
 		ToUser:                 userID,
 		MsgType:                "text",
@@ -29,11 +50,11 @@ func SendTextMessage(agentID int, content string, userID string) (*entity.Messag
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal message: %v", err)
 	}
-	return doRequest(payloadBytes)
+	return c.doRequest(payloadBytes)
 }
 
 // SendMarkdownMessage sends a text message using the WeChat Work API
-func SendMarkdownMessage(agentID int, content string, userID string) (*entity.MessageResponse, error) {
+func (c *Client) SendMarkdownMessage(agentID int, content string, userID string) (*entity.MessageResponse, error) {
 	msg := entity.MarkdownMessage{
 		ToUser:                 userID,
 		MsgType:                "markdown",
@@ -47,27 +68,75 @@ func SendMarkdownMessage(agentID int, content string, userID string) (*entity.Me
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal message: %v", err)
 	}
-	return doRequest(payloadBytes)
+	return c.doRequest(payloadBytes)
 }
 
-func doRequest(payloadBytes []byte) (*entity.MessageResponse, error) {
-	accessToken := cron.GetAccessToken()
-	url := fmt.Sprintf("%s?access_token=%s", entity.WxMessageSendURL, accessToken)
+// SendFileMessage sends a file message using the WeChat Work API, mediaID comes from UploadMedia
+func (c *Client) SendFileMessage(agentID int, mediaID string, userID string) (*entity.MessageResponse, error) {
+	msg := entity.FileMessage{
+		ToUser:                 userID,
+		MsgType:                "file",
+		AgentID:                agentID,
+		File:                   entity.MediaBody{MediaID: mediaID},
+		Safe:                   0,
+		EnableDuplicateCheck:   0,
+		DuplicateCheckInterval: 1800,
+	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(payloadBytes))
+	payloadBytes, err := json.Marshal(msg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %v", err)
+		return nil, fmt.Errorf("failed to marshal message: %v", err)
 	}
-	defer resp.Body.Close()
+	return c.doRequest(payloadBytes)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// UploadMedia uploads a temporary media file using the WeChat Work API, mediaType is one of image, voice, video, file
+func (c *Client) UploadMedia(mediaType string, filename string, data []byte) (*entity.MediaUploadResponse, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("media", filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to create form file: %v", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write form file: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+
+	var result entity.MediaUploadResponse
+	if err := c.postWithToken(entity.WxMediaUploadURL, "&type="+mediaType, writer.FormDataContentType(), body.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("failed to upload media: %v", err)
 	}
 
+	if result.ErrCode != 0 {
+		log.Printf("Media uploading failed with error: %s", result.ErrMsg)
+		return &result, fmt.Errorf("API error: %s", result.ErrMsg)
+	}
+
+	return &result, nil
+}
+
+// GetUser gets a member of the corp using the WeChat Work API, the member must be visible to the app
+func (c *Client) GetUser(userID string) (*entity.UserResponse, error) {
+	var result entity.UserResponse
+	if err := c.requestWithToken(http.MethodGet, entity.WxUserGetURL, "&userid="+url.QueryEscape(userID), "", nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	if result.ErrCode != 0 {
+		log.Printf("User getting failed with error: %s", result.ErrMsg)
+		return &result, fmt.Errorf("API error: %s", result.ErrMsg)
+	}
+
+	return &result, nil
+}
+
+func (c *Client) doRequest(payloadBytes []byte) (*entity.MessageResponse, error) {
 	var result entity.MessageResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	if err := c.postWithToken(entity.WxMessageSendURL, "", "application/json", payloadBytes, &result); err != nil {
+		return nil, fmt.Errorf("failed to send message: %v", err)
 	}
 
 	if result.ErrCode != 0 {
@@ -77,3 +146,53 @@ func doRequest(payloadBytes []byte) (*entity.MessageResponse, error) {
 
 	return &result, nil
 }
+
+// postWithToken posts body to apiURL with the cached access token and unmarshals the response into result.
+func (c *Client) postWithToken(apiURL string, params string, contentType string, body []byte, result interface{}) error {
+	return c.requestWithToken(http.MethodPost, apiURL, params, contentType, body, result)
+}
+
+// requestWithToken calls apiURL with the cached access token and unmarshals the response into result.
+// If the token is rejected, it forces a token refresh and retries once.
+func (c *Client) requestWithToken(method string, apiURL string, params string, contentType string, body []byte, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		accessToken, err := c.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get access token: %v", err)
+		}
+		req, err := http.NewRequest(method, fmt.Sprintf("%s?access_token=%s%s", apiURL, accessToken, params), bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			// mask the access token in the URL
+			return redact.Error(err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %v", err)
+		}
+
+		var status struct {
+			ErrCode int `json:"errcode"`
+		}
+		if err := json.Unmarshal(respBody, &status); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		if attempt == 0 && cron.IsTokenInvalid(status.ErrCode) {
+			log.Printf("Access token rejected with errcode %d, refresh and retry", status.ErrCode)
+			if err := c.tokens.ForceRefresh(); err == nil {
+				continue
+			}
+		}
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		return nil
+	}
+}
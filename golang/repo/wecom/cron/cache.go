@@ -0,0 +1,54 @@
+package cron
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cache shares access tokens between replicas and across restarts, so only one instance calls gettoken.
+// Implementations must be safe for concurrent use by several processes.
+type Cache interface {
+	// Get returns the cached token of key, ok is false if there is none
+	Get(ctx context.Context, key string) (token CachedToken, ok bool, err error)
+	// Set stores the token of key until it expires
+	Set(ctx context.Context, key string, token CachedToken) error
+	// AcquireLease tries to take the exclusive right to refresh key for at most ttl.
+	// acquired is false if another instance holds it; release must be called after refreshing.
+	AcquireLease(ctx context.Context, key string, ttl time.Duration) (release func(), acquired bool, err error)
+}
+
+// CachedToken is an access token stored in a Cache
+type CachedToken struct {
+	AccessToken string    `json:"access_token"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// refreshAt returns when the token is due for refresh, early by a margin proportional to its lifetime
+func (t CachedToken) refreshAt() time.Time {
+	return t.ExpiresAt.Add(-refreshMargin(t.ExpiresAt.Sub(t.FetchedAt)))
+}
+
+// NewCache creates a cache from spec: "file:<dir>" for a single host,
+// "redis://[:password@]host:port[/db]" for a cluster, or "" for no cache
+func NewCache(spec string) (Cache, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileCache(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "redis://"):
+		return NewRedisCache(spec)
+	}
+	return nil, fmt.Errorf("unknown token cache %q, want file:<dir> or redis://host:port", spec)
+}
+
+// cacheKey identifies an app in the cache without exposing its secret
+func cacheKey(cred tokenCredentials) string {
+	sum := sha256.Sum256([]byte(cred.secret))
+	return fmt.Sprintf("wecom:access_token:%s:%s", cred.corpID, hex.EncodeToString(sum[:8]))
+}
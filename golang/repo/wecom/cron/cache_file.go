@@ -0,0 +1,79 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileCache stores tokens as JSON files in a directory shared by the processes on one host.
+// Leases are file locks, released automatically if the holder exits.
+type FileCache struct {
+	dir string
+}
+
+// NewFileCache creates a file cache in dir, creating the directory if needed
+func NewFileCache(dir string) (*FileCache, error) {
+	if dir == "" {
+		return nil, errors.New("token cache directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create token cache directory: %v", err)
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+// Get implements Cache
+func (c *FileCache) Get(ctx context.Context, key string) (CachedToken, bool, error) {
+	data, err := os.ReadFile(c.path(key, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return CachedToken{}, false, nil
+	} else if err != nil {
+		return CachedToken{}, false, fmt.Errorf("failed to read cached token: %v", err)
+	}
+	var token CachedToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return CachedToken{}, false, fmt.Errorf("failed to unmarshal cached token: %v", err)
+	}
+	return token, token.AccessToken != "", nil
+}
+
+// Set implements Cache, the file is replaced atomically so readers never see partial content
+func (c *FileCache) Set(ctx context.Context, key string, token CachedToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %v", err)
+	}
+	tmp, err := os.CreateTemp(c.dir, ".token-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key, ".json")); err != nil {
+		return fmt.Errorf("failed to replace cached token: %v", err)
+	}
+	return nil
+}
+
+// AcquireLease implements Cache
+func (c *FileCache) AcquireLease(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	return tryLockFile(c.path(key, ".lock"), ttl)
+}
+
+// path maps a cache key to a file name in the cache directory
+func (c *FileCache) path(key, ext string) string {
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(key)
+	return filepath.Join(c.dir, name+ext)
+}
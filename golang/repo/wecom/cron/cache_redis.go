@@ -0,0 +1,209 @@
+package cron
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releaseLeaseScript deletes the lease only if it is still held by the caller
+const releaseLeaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+const redisTimeout = 3 * time.Second
+
+// errRedisNil is the RESP null reply
+var errRedisNil = errors.New("redis: nil")
+
+// RedisCache stores tokens in a Redis compatible server shared by a cluster.
+// Leases are keys set with NX and a PX expiry. Tokens are refreshed every couple of hours,
+// so each command dials a new connection instead of keeping a pool.
+type RedisCache struct {
+	addr     string
+	password string
+	db       int
+}
+
+// NewRedisCache creates a cache from redis://[:password@]host:port[/db]
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis url")
+	}
+	c := &RedisCache{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis db %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Get implements Cache
+func (c *RedisCache) Get(ctx context.Context, key string) (CachedToken, bool, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if errors.Is(err, errRedisNil) {
+		return CachedToken{}, false, nil
+	} else if err != nil {
+		return CachedToken{}, false, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return CachedToken{}, false, fmt.Errorf("unexpected redis reply %T", reply)
+	}
+	var token CachedToken
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return CachedToken{}, false, fmt.Errorf("failed to unmarshal cached token: %v", err)
+	}
+	return token, token.AccessToken != "", nil
+}
+
+// Set implements Cache, the key expires with the token
+func (c *RedisCache) Set(ctx context.Context, key string, token CachedToken) error {
+	ttl := time.Until(token.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %v", err)
+	}
+	_, err = c.do(ctx, "SET", key, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// AcquireLease implements Cache
+func (c *RedisCache) AcquireLease(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, false, err
+	}
+	leaseKey, leaseOwner := key+":lease", hex.EncodeToString(owner)
+	_, err := c.do(ctx, "SET", leaseKey, leaseOwner, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		c.do(ctx, "EVAL", releaseLeaseScript, "1", leaseKey, leaseOwner)
+	}
+	return release, true, nil
+}
+
+// do sends one command on a new connection, authenticating and selecting the db first if configured
+func (c *RedisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect redis: %v", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	var commands [][]string
+	if c.password != "" {
+		commands = append(commands, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	commands = append(commands, args)
+
+	// pipeline all commands, then read the replies in order
+	w := bufio.NewWriter(conn)
+	for _, cmd := range commands {
+		writeRESPCommand(w, cmd)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write redis command: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	var reply interface{}
+	for i, cmd := range commands {
+		reply, err = readRESP(r)
+		if err != nil && (i < len(commands)-1 || !errors.Is(err, errRedisNil)) {
+			return nil, fmt.Errorf("redis %s failed: %v", cmd[0], err)
+		}
+	}
+	return reply, err
+}
+
+// writeRESPCommand writes a command as a RESP array of bulk strings
+func writeRESPCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readRESP reads one RESP reply: simple strings and bulk strings as string, integers as int64,
+// arrays as []interface{}, errors as error and null replies as errRedisNil
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length: %v", err)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length: %v", err)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply %q", line)
+}
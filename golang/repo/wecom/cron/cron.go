@@ -1,65 +1,370 @@
 package cron
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
+
+	"example.com/play/utils/redact"
 )
 
-// GetAccessToken returns the cached access token
-func GetAccessToken() string {
-	tokenMutex.RLock()
-	defer tokenMutex.RUnlock()
-	return accessToken
+// Manager caches and refreshes access tokens of several WeCom apps, keyed by (corpID, secret) or by a custom key.
+// Tokens are fetched lazily on first use and refreshed in the background. It is safe for concurrent use.
+type Manager struct {
+	httpClient *http.Client
+	cache      Cache
+
+	mutex   sync.Mutex
+	sources map[string]*Source
+	stopped bool
+}
+
+// Fetcher obtains a new token, e.g. a suite access token or a corp access token through the suite
+type Fetcher func() (*AccessTokenResponse, error)
+
+// ManagerOption configures a Manager
+type ManagerOption func(*Manager)
+
+// WithCache shares tokens through cache, so replicas and restarts reuse one token and
+// only the instance holding the lease calls gettoken
+func WithCache(cache Cache) ManagerOption {
+	return func(m *Manager) { m.cache = cache }
+}
+
+// NewManager creates a token manager
+func NewManager(opts ...ManagerOption) *Manager {
+	m := &Manager{httpClient: &http.Client{Timeout: 10 * time.Second}, sources: map[string]*Source{}}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Source returns the token source of a self-built app calling gettoken, creating it on first call without fetching a token
+func (m *Manager) Source(corpID string, secret string) *Source {
+	cred := tokenCredentials{corpID: corpID, secret: secret}
+	return m.CustomSource("corp "+corpID, cacheKey(cred), func() (*AccessTokenResponse, error) {
+		return m.fetchToken(corpID, secret)
+	})
+}
+
+// CustomSource returns the token source identified by key, creating it on first call without fetching a token.
+// key also names the token in the cache, so it must not contain secrets; name is only used in logs.
+// An existing source keeps its token but switches to fetch, so credentials changed by a config reload
+// (e.g. a rotated suite secret) are used from the next refresh on.
+func (m *Manager) CustomSource(name string, key string, fetch Fetcher) *Source {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.sources[key]
+	if !ok {
+		s = &Source{manager: m, name: name, key: key, fetcher: fetch}
+		m.sources[key] = s
+	}
+	s.fetcher = fetch
+	return s
+}
+
+// Stop stops refreshing the tokens of all apps
+func (m *Manager) Stop() {
+	m.mutex.Lock()
+	m.stopped = true
+	sources := make([]*Source, 0, len(m.sources))
+	for _, s := range m.sources {
+		sources = append(sources, s)
+	}
+	m.mutex.Unlock()
+
+	for _, s := range sources {
+		s.stopTimer()
+	}
+	log.Println("Token refresher stopped")
+}
+
+func (m *Manager) isStopped() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stopped
+}
+
+// Source is the access token of one WeCom app
+type Source struct {
+	manager *Manager
+	name    string
+	key     string
+	fetcher Fetcher // guarded by manager.mutex
+
+	tokenMutex  sync.RWMutex
+	accessToken string
+	status      Status
+
+	firstFetch   sync.Once
+	refreshMutex sync.Mutex
+	forceMutex   sync.Mutex
+	timerMutex   sync.Mutex
+	timer        *time.Timer
+	closed       bool
+}
+
+// Token returns the cached access token, fetching it on first use.
+// Later calls never block on the network: a failed fetch is retried with backoff in the background.
+func (s *Source) Token() (string, error) {
+	s.firstFetch.Do(func() {
+		s.refresh(false)
+	})
+	s.tokenMutex.RLock()
+	defer s.tokenMutex.RUnlock()
+	if s.accessToken == "" {
+		return "", s.status.LastError
+	}
+	return s.accessToken, nil
+}
+
+// Status returns the refresher status for health checks
+func (s *Source) Status() Status {
+	s.tokenMutex.RLock()
+	defer s.tokenMutex.RUnlock()
+	return s.status
+}
+
+// Healthy reports whether the cached access token has been fetched and not yet expired
+func (s Status) Healthy() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().Before(s.ExpiresAt)
+}
+
+// ForceRefresh fetches a new token immediately, used when the API reports the cached token is invalid.
+// Concurrent callers share one fetch, and a token fetched within the last few seconds is reused.
+func (s *Source) ForceRefresh() error {
+	s.forceMutex.Lock()
+	defer s.forceMutex.Unlock()
+	if last := s.Status().LastSuccess; !last.IsZero() && time.Since(last) < minForceRefreshInterval {
+		return nil
+	}
+	log.Printf("Forcing refresh of access token of %s", s.name)
+	return s.refresh(true)
+}
+
+// refresh obtains a token and schedules the next refresh:
+// on success early by a margin proportional to the token lifetime, on failure after an exponential backoff.
+// force skips a cached token equal to the current one, which the API has rejected.
+func (s *Source) refresh(force bool) error {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	token, err := s.obtain(force)
+	if err != nil {
+		s.tokenMutex.Lock()
+		s.status.LastError = err
+		s.status.LastErrorAt = time.Now()
+		s.status.ConsecutiveFailures++
+		backoff := retryBackoff(s.status.ConsecutiveFailures)
+		s.tokenMutex.Unlock()
+
+		log.Printf("Failed to refresh access token of %s, retry in %v: %v", s.name, backoff, err)
+		s.schedule(backoff)
+		return err
+	}
+
+	s.tokenMutex.Lock()
+	s.accessToken = token.AccessToken
+	s.status.LastSuccess = time.Now()
+	s.status.ExpiresAt = token.ExpiresAt
+	s.status.ConsecutiveFailures = 0
+	s.tokenMutex.Unlock()
+
+	refreshTime := time.Until(token.refreshAt())
+	if refreshTime < minRetryBackoff {
+		refreshTime = minRetryBackoff
+	}
+	s.schedule(refreshTime)
+	log.Printf("Access token of %s refreshed, will refresh again in %v", s.name, refreshTime)
+	return nil
 }
 
-// StartTokenRefresher starts the token refresh routine
-func StartTokenRefresher(corpID string, secret string) {
-	refreshToken(corpID, secret)
+// obtain returns a usable token from the cache, or fetches one while holding the cache lease.
+// If the cache is unavailable it falls back to fetching directly.
+func (s *Source) obtain(force bool) (CachedToken, error) {
+	cache := s.manager.cache
+	if cache == nil {
+		return s.fetch()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), leaseWait)
+	defer cancel()
+	key := s.key
+	for {
+		if token, ok := s.cached(ctx, key, force); ok {
+			return token, nil
+		}
+		release, acquired, err := cache.AcquireLease(ctx, key, leaseTTL)
+		if err != nil {
+			log.Printf("Acquire token cache lease failed, fetch directly: %v", err)
+			return s.fetch()
+		}
+		if acquired {
+			defer release()
+			// another instance may have refreshed between the lookup and the lease
+			if token, ok := s.cached(ctx, key, force); ok {
+				return token, nil
+			}
+			token, err := s.fetch()
+			if err != nil {
+				return token, err
+			}
+			if err := cache.Set(ctx, key, token); err != nil {
+				log.Printf("Save token to cache failed: %v", err)
+			}
+			return token, nil
+		}
+		// another instance is refreshing, wait for it to fill the cache
+		select {
+		case <-ctx.Done():
+			return CachedToken{}, errors.New("timed out waiting for another instance to refresh the access token")
+		case <-time.After(leasePollInterval):
+		}
+	}
 }
 
-func refreshToken(corpID string, secret string) {
-	url := fmt.Sprintf("%s?corpid=%s&corpsecret=%s", WxTokenURL, corpID, secret)
+// cached returns the token in the cache if it is not due for refresh
+func (s *Source) cached(ctx context.Context, key string, force bool) (CachedToken, bool) {
+	token, ok, err := s.manager.cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("Read token cache failed: %v", err)
+		return CachedToken{}, false
+	}
+	if !ok || !time.Now().Before(token.refreshAt()) {
+		return CachedToken{}, false
+	}
+	if force {
+		s.tokenMutex.RLock()
+		rejected := token.AccessToken == s.accessToken
+		s.tokenMutex.RUnlock()
+		if rejected {
+			return CachedToken{}, false
+		}
+	}
+	return token, true
+}
 
-	resp, err := http.Get(url)
+// fetch calls the current fetcher of the source
+func (s *Source) fetch() (CachedToken, error) {
+	s.manager.mutex.Lock()
+	fetcher := s.fetcher
+	s.manager.mutex.Unlock()
+	tokenResp, err := fetcher()
 	if err != nil {
-		log.Printf("Failed to get access token: %v", err)
+		return CachedToken{}, err
+	}
+	if tokenResp.AccessToken == "" || tokenResp.ExpiresIn <= 0 {
+		return CachedToken{}, errors.New("error getting access token: empty token or expires_in")
+	}
+	now := time.Now()
+	return CachedToken{
+		AccessToken: tokenResp.AccessToken,
+		FetchedAt:   now,
+		ExpiresAt:   now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Close stops refreshing the token and removes the source from its manager,
+// e.g. when a corp uninstalls a suite. Later calls of Source or CustomSource create a new source.
+func (s *Source) Close() {
+	s.manager.mutex.Lock()
+	if s.manager.sources[s.key] == s {
+		delete(s.manager.sources, s.key)
+	}
+	s.manager.mutex.Unlock()
+
+	s.timerMutex.Lock()
+	s.closed = true
+	s.timerMutex.Unlock()
+	s.stopTimer()
+}
+
+// schedule runs refresh after d unless the source is closed or the manager is stopped
+func (s *Source) schedule(d time.Duration) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+	if s.closed || s.manager.isStopped() {
 		return
 	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(d, func() {
+		s.refresh(false)
+	})
+}
+
+func (s *Source) stopTimer() {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// refreshMargin returns how long before expiration to refresh, a fraction of the lifetime with a lower bound
+func refreshMargin(expiresIn time.Duration) time.Duration {
+	margin := expiresIn / refreshMarginRatio
+	if margin < minRefreshMargin {
+		margin = minRefreshMargin
+	}
+	if margin >= expiresIn {
+		margin = expiresIn / 2
+	}
+	return margin
+}
+
+// retryBackoff returns the delay before the n-th retry, doubling from minRetryBackoff up to maxRetryBackoff
+func retryBackoff(failures int) time.Duration {
+	backoff := minRetryBackoff
+	for i := 1; i < failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+func (m *Manager) fetchToken(corpID string, secret string) (*AccessTokenResponse, error) {
+	tokenURL := fmt.Sprintf("%s?corpid=%s&corpsecret=%s", WxTokenURL, url.QueryEscape(corpID), url.QueryEscape(secret))
+
+	resp, err := m.httpClient.Get(tokenURL)
+	if err != nil {
+		// the error is kept in Status and exposed by health checks, mask corpsecret in the URL
+		return nil, fmt.Errorf("failed to get access token: %v", redact.Error(err))
+	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read response body: %v", err)
-		return
+		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
 	var tokenResp AccessTokenResponse
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		log.Printf("Failed to unmarshal response: %v", err)
-		return
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
 	if tokenResp.ErrCode != 0 {
-		log.Printf("Error getting access token: %s", tokenResp.ErrMsg)
-		return
+		return nil, fmt.Errorf("error getting access token: %d %s", tokenResp.ErrCode, tokenResp.ErrMsg)
 	}
+	return &tokenResp, nil
+}
 
-	tokenMutex.Lock()
-	accessToken = tokenResp.AccessToken
-	tokenMutex.Unlock()
-
-	// Schedule next refresh 10 seconds before expiration
-	refreshTime := time.Duration(tokenResp.ExpiresIn-10) * time.Second
-	if timer != nil {
-		timer.Stop()
+// IsTokenInvalid reports whether a WeCom API errcode means the access token is invalid or expired
+func IsTokenInvalid(errCode int) bool {
+	switch errCode {
+	case ErrCodeInvalidCredential, ErrCodeInvalidAccessToken, ErrCodeAccessTokenExpired:
+		return true
 	}
-	timer = time.AfterFunc(refreshTime, func() {
-		refreshToken(corpID, secret)
-	})
-
-	log.Printf("Access token refreshed, will refresh again in %v", refreshTime)
+	return false
 }
@@ -1,7 +1,6 @@
 package cron
 
 import (
-	"sync"
 	"time"
 )
 
@@ -9,12 +8,42 @@ const (
 	WxTokenURL = "https://qyapi.weixin.qq.com/cgi-bin/gettoken"
 )
 
-var (
-	accessToken string
-	tokenMutex  sync.RWMutex
-	timer       *time.Timer
+// WeCom API errcodes meaning the access token has to be refreshed
+const (
+	ErrCodeInvalidCredential  = 40001 // 不合法的secret参数
+	ErrCodeInvalidAccessToken = 40014 // 不合法的access_token
+	ErrCodeAccessTokenExpired = 42001 // access_token已过期
+)
+
+const (
+	// refreshMarginRatio refreshes 1/5 of the lifetime before expiration, 24 minutes for the usual 7200s
+	refreshMarginRatio = 5
+	minRefreshMargin   = 10 * time.Second
+	minRetryBackoff    = time.Second
+	maxRetryBackoff    = 5 * time.Minute
+	// minForceRefreshInterval ignores forced refreshes right after a successful one
+	minForceRefreshInterval = 5 * time.Second
+	// leaseTTL bounds how long an instance may hold the cache lease while fetching
+	leaseTTL = 30 * time.Second
+	// leaseWait is how long to wait for another instance to fill the cache
+	leaseWait         = 15 * time.Second
+	leasePollInterval = 500 * time.Millisecond
 )
 
+type tokenCredentials struct {
+	corpID string
+	secret string
+}
+
+// Status is the token refresher status
+type Status struct {
+	LastSuccess         time.Time // when the current token was fetched
+	ExpiresAt           time.Time // when the current token expires
+	LastError           error     // the latest fetch error, kept after later successes
+	LastErrorAt         time.Time
+	ConsecutiveFailures int // fetch failures since the last success
+}
+
 type AccessTokenResponse struct {
 	ErrCode     int    `json:"errcode"`
 	ErrMsg      string `json:"errmsg"`
@@ -0,0 +1,31 @@
+//go:build !unix
+
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// tryLockFile creates path exclusively as a lock. Without flock the lock is not released if the process
+// crashes, so a lock file older than ttl is considered stale and taken over.
+func tryLockFile(path string, ttl time.Duration) (func(), bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, true, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, false, fmt.Errorf("failed to create lock file: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < ttl {
+			return nil, false, nil
+		}
+		os.Remove(path)
+	}
+	return nil, false, nil
+}
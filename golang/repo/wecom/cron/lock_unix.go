@@ -0,0 +1,32 @@
+//go:build unix
+
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// tryLockFile takes a non-blocking exclusive flock on path. The kernel releases it if the process exits,
+// so ttl is not needed here.
+func tryLockFile(path string, ttl time.Duration) (func(), bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to lock file: %v", err)
+	}
+	release := func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+	return release, true, nil
+}
@@ -1,7 +1,11 @@
 package entity
 
+import "encoding/xml"
+
 const (
 	WxMessageSendURL = "https://qyapi.weixin.qq.com/cgi-bin/message/send"
+	WxMediaUploadURL = "https://qyapi.weixin.qq.com/cgi-bin/media/upload"
+	WxUserGetURL     = "https://qyapi.weixin.qq.com/cgi-bin/user/get"
 )
 
 // TextMessage 普通文本消息
@@ -30,11 +34,47 @@ type MarkdownMessage struct {
 	DuplicateCheckInterval int      `json:"duplicate_check_interval"`
 }
 
+// FileMessage 文件消息
+type FileMessage struct {
+	ToUser                 string    `json:"touser,omitempty"`
+	ToParty                string    `json:"toparty,omitempty"`
+	ToTag                  string    `json:"totag,omitempty"`
+	MsgType                string    `json:"msgtype"`
+	AgentID                int       `json:"agentid"`
+	File                   MediaBody `json:"file"`
+	Safe                   int       `json:"safe"`
+	EnableDuplicateCheck   int       `json:"enable_duplicate_check"`
+	DuplicateCheckInterval int       `json:"duplicate_check_interval"`
+}
+
 // TextBody 文本内容
 type TextBody struct {
 	Content string `json:"content"`
 }
 
+// MediaBody 媒体文件内容
+type MediaBody struct {
+	MediaID string `json:"media_id"`
+}
+
+// MediaUploadResponse 企业微信上传临时素材响应体，media_id 仅三天内有效
+type MediaUploadResponse struct {
+	ErrCode   int    `json:"errcode"`
+	ErrMsg    string `json:"errmsg"`
+	Type      string `json:"type"`
+	MediaID   string `json:"media_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// UserResponse 企业微信读取成员响应体，只包含用到的字段
+type UserResponse struct {
+	ErrCode    int     `json:"errcode"`
+	ErrMsg     string  `json:"errmsg"`
+	UserID     string  `json:"userid"`
+	Name       string  `json:"name"`
+	Department []int64 `json:"department"` // 成员所属部门ID列表
+}
+
 // MessageResponse 企业微信发送应用消息响应体
 type MessageResponse struct {
 	ErrCode        int    `json:"errcode"`
@@ -71,6 +111,21 @@ type WxBizMsg struct {
 	EventKey     string  `xml:"EventKey,omitempty"`     //  事件-事件内容
 }
 
+// CDATA XML中以CDATA形式输出的文本
+type CDATA struct {
+	Value string `xml:",cdata"`
+}
+
+// WxBizReplyTextMsg 企业微信自建应用被动回复的文本消息结构体，需加密后写入回调响应
+type WxBizReplyTextMsg struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   CDATA    `xml:"ToUserName"`   // 成员UserID
+	FromUserName CDATA    `xml:"FromUserName"` // 企业微信CorpID
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      CDATA    `xml:"MsgType"`
+	Content      CDATA    `xml:"Content"`
+}
+
 // MsgType 消息类型
 type MsgType string
 
@@ -0,0 +1,135 @@
+package keyring
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"sync"
+
+	"example.com/play/repo/wecom/wxbizmsgcrypt"
+)
+
+// KeyPair 企业微信接收消息配置中的一组 Token 与 EncodingAESKey
+type KeyPair struct {
+	Token          string
+	EncodingAESKey string
+}
+
+// ID 返回密钥对的短标识，仅用于日志和统计，避免输出密钥原文
+func (p KeyPair) ID() string {
+	sum := sha1.Sum([]byte(p.Token + ":" + p.EncodingAESKey))
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+// KeyRing 同时持有多组有效密钥（当前与历史），验签和解密时按顺序逐个尝试，
+// 使得在企业微信后台轮换 Token/EncodingAESKey 时无需停机。
+type KeyRing struct {
+	receiverID string
+
+	mu    sync.RWMutex
+	pairs []KeyPair
+	hits  map[string]uint64
+}
+
+// New 创建密钥环，pairs 的第一项视为当前密钥
+func New(receiverID string, pairs []KeyPair) *KeyRing {
+	k := &KeyRing{receiverID: receiverID, hits: make(map[string]uint64)}
+	k.SetKeys(pairs)
+	return k
+}
+
+// SetKeys 原子替换全部有效密钥，用于配置重载时增删密钥
+func (k *KeyRing) SetKeys(pairs []KeyPair) {
+	deduped := make([]KeyPair, 0, len(pairs))
+	seen := make(map[KeyPair]bool, len(pairs))
+	for _, p := range pairs {
+		if p.Token == "" || p.EncodingAESKey == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		deduped = append(deduped, p)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.pairs = deduped
+	ids := make([]string, 0, len(deduped))
+	for _, p := range deduped {
+		ids = append(ids, p.ID())
+	}
+	log.Printf("KeyRing updated, active keys: %v", ids)
+}
+
+// Keys 返回当前全部有效密钥的副本
+func (k *KeyRing) Keys() []KeyPair {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]KeyPair(nil), k.pairs...)
+}
+
+// Stats 返回每组密钥的命中次数，key 为 KeyPair.ID()
+func (k *KeyRing) Stats() map[string]uint64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	stats := make(map[string]uint64, len(k.hits))
+	for id, n := range k.hits {
+		stats[id] = n
+	}
+	return stats
+}
+
+// VerifyURL 依次使用各组密钥验证回调URL，返回解密后的 echostr 及命中的密钥
+func (k *KeyRing) VerifyURL(msgSignature, timestamp, nonce, echoStr string) ([]byte, KeyPair, *wxbizmsgcrypt.CryptError) {
+	return k.try("VerifyURL", func(c *wxbizmsgcrypt.WXBizMsgCrypt) ([]byte, *wxbizmsgcrypt.CryptError) {
+		return c.VerifyURL(msgSignature, timestamp, nonce, echoStr)
+	})
+}
+
+// DecryptMsg 依次使用各组密钥验签并解密消息，返回明文及命中的密钥
+func (k *KeyRing) DecryptMsg(msgSignature, timestamp, nonce string, postData []byte) ([]byte, KeyPair, *wxbizmsgcrypt.CryptError) {
+	return k.try("DecryptMsg", func(c *wxbizmsgcrypt.WXBizMsgCrypt) ([]byte, *wxbizmsgcrypt.CryptError) {
+		return c.DecryptMsg(msgSignature, timestamp, nonce, postData)
+	})
+}
+
+// EncryptMsg 使用指定密钥加密被动回复，pair 一般为解密请求时命中的密钥
+func (k *KeyRing) EncryptMsg(pair KeyPair, replyMsg, timestamp, nonce string) ([]byte, *wxbizmsgcrypt.CryptError) {
+	return k.newCrypt(pair).EncryptMsg(replyMsg, timestamp, nonce)
+}
+
+func (k *KeyRing) newCrypt(pair KeyPair) *wxbizmsgcrypt.WXBizMsgCrypt {
+	return wxbizmsgcrypt.NewWXBizMsgCrypt(pair.Token, pair.EncodingAESKey, k.receiverID, wxbizmsgcrypt.XmlType)
+}
+
+func (k *KeyRing) try(op string, fn func(c *wxbizmsgcrypt.WXBizMsgCrypt) ([]byte, *wxbizmsgcrypt.CryptError)) ([]byte, KeyPair, *wxbizmsgcrypt.CryptError) {
+	pairs := k.Keys()
+	if len(pairs) == 0 {
+		return nil, KeyPair{}, wxbizmsgcrypt.NewCryptError(wxbizmsgcrypt.IllegalAesKey, "no active key")
+	}
+
+	// 签名只与 Token 相关，仅轮换 EncodingAESKey 时多组密钥都能验签通过，
+	// 因此遇到任何错误都继续尝试，全部失败时优先返回验签之外的错误。
+	var firstErr *wxbizmsgcrypt.CryptError
+	for i, pair := range pairs {
+		result, cryptErr := fn(k.newCrypt(pair))
+		if cryptErr == nil {
+			k.record(pair)
+			if i > 0 {
+				log.Printf("%s matched non-current key #%d (%s)", op, i, pair.ID())
+			} else {
+				log.Printf("%s matched current key (%s)", op, pair.ID())
+			}
+			return result, pair, nil
+		}
+		if firstErr == nil || firstErr.ErrCode == wxbizmsgcrypt.ValidateSignatureError {
+			firstErr = cryptErr
+		}
+	}
+	return nil, KeyPair{}, firstErr
+}
+
+func (k *KeyRing) record(pair KeyPair) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.hits[pair.ID()]++
+}
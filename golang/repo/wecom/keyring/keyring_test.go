@@ -0,0 +1,63 @@
+package keyring
+
+import (
+	"encoding/xml"
+	"fmt"
+	"testing"
+)
+
+const (
+	testCorpID = "ww0123456789abcdef"
+	testToken  = "token"
+	// 两个 EncodingAESKey 为 32 字节密钥的 base64 编码去掉末尾的“=”
+	oldAESKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY"
+	newAESKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA"
+)
+
+// encrypted 企业微信推送的加密消息
+type encrypted struct {
+	Encrypt      string `xml:"Encrypt"`
+	MsgSignature string `xml:"MsgSignature"`
+	TimeStamp    string `xml:"TimeStamp"`
+	Nonce        string `xml:"Nonce"`
+}
+
+// TestDecryptMsgRotatedAESKey 只轮换 EncodingAESKey 时两组密钥的 Token 相同，都能验签通过，
+// 无论哪组在前，都应在使用错误密钥解密失败后继续尝试下一组
+func TestDecryptMsgRotatedAESKey(t *testing.T) {
+	oldPair := KeyPair{Token: testToken, EncodingAESKey: oldAESKey}
+	newPair := KeyPair{Token: testToken, EncodingAESKey: newAESKey}
+	tests := []struct {
+		name   string
+		pairs  []KeyPair
+		sender KeyPair
+	}{
+		{name: "current key first", pairs: []KeyPair{newPair, oldPair}, sender: newPair},
+		{name: "stale key first", pairs: []KeyPair{oldPair, newPair}, sender: newPair},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := New(testCorpID, tt.pairs)
+			sender := New(testCorpID, []KeyPair{tt.sender})
+			// 加密结果含随机串，多次尝试覆盖错误密钥解密出的各种随机数据
+			for i := 0; i < 200; i++ {
+				want := fmt.Sprintf("<xml><Content>%d</Content></xml>", i)
+				data, cryptErr := sender.EncryptMsg(tt.sender, want, "1700000000", "nonce")
+				if cryptErr != nil {
+					t.Fatalf("encrypt: %v", cryptErr)
+				}
+				var msg encrypted
+				if err := xml.Unmarshal(data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				got, pair, cryptErr := ring.DecryptMsg(msg.MsgSignature, msg.TimeStamp, msg.Nonce, data)
+				if cryptErr != nil {
+					t.Fatalf("decrypt #%d: %d %s", i, cryptErr.ErrCode, cryptErr.ErrMsg)
+				}
+				if string(got) != want || pair != tt.sender {
+					t.Fatalf("decrypt #%d: got %q with key %s, want %q with key %s", i, got, pair.ID(), want, tt.sender.ID())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package suite
+
+import "encoding/xml"
+
+const (
+	WxSuiteTokenURL     = "https://qyapi.weixin.qq.com/cgi-bin/service/get_suite_token"
+	WxPermanentCodeURL  = "https://qyapi.weixin.qq.com/cgi-bin/service/get_permanent_code"
+	WxAuthInfoURL       = "https://qyapi.weixin.qq.com/cgi-bin/service/get_auth_info"
+	WxCorpTokenURL      = "https://qyapi.weixin.qq.com/cgi-bin/service/get_corp_token"
+	suiteTokenKeyPrefix = "wecom:suite_access_token:"
+	corpTokenKeyPrefix  = "wecom:corp_access_token:"
+)
+
+// InfoType of instruction callbacks
+const (
+	InfoTypeSuiteTicket = "suite_ticket" // pushed every 10 minutes, valid for 30 minutes
+	InfoTypeCreateAuth  = "create_auth"  // a corp installed the suite, carries a temporary auth code
+	InfoTypeChangeAuth  = "change_auth"  // a corp changed the authorized scope
+	InfoTypeCancelAuth  = "cancel_auth"  // a corp uninstalled the suite
+)
+
+// Instruction is a decrypted instruction callback sent to the suite
+type Instruction struct {
+	XMLName     xml.Name `xml:"xml"`
+	SuiteID     string   `xml:"SuiteId"`
+	InfoType    string   `xml:"InfoType"`
+	TimeStamp   int64    `xml:"TimeStamp"`
+	SuiteTicket string   `xml:"SuiteTicket,omitempty"`
+	AuthCode    string   `xml:"AuthCode,omitempty"`
+	AuthCorpID  string   `xml:"AuthCorpId,omitempty"`
+}
+
+// Auth is a corp that installed the suite
+type Auth struct {
+	CorpID        string `json:"corp_id"`
+	CorpName      string `json:"corp_name"`
+	AgentID       int64  `json:"agent_id"` // the agent ID of the suite in the corp
+	PermanentCode string `json:"permanent_code"`
+}
+
+type suiteTokenRequest struct {
+	SuiteID     string `json:"suite_id"`
+	SuiteSecret string `json:"suite_secret"`
+	SuiteTicket string `json:"suite_ticket"`
+}
+
+type suiteTokenResponse struct {
+	ErrCode          int    `json:"errcode"`
+	ErrMsg           string `json:"errmsg"`
+	SuiteAccessToken string `json:"suite_access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+}
+
+type permanentCodeRequest struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type corpRequest struct {
+	AuthCorpID    string `json:"auth_corpid"`
+	PermanentCode string `json:"permanent_code"`
+}
+
+// authInfoResponse is the response of get_permanent_code and get_auth_info, only the used fields
+type authInfoResponse struct {
+	ErrCode       int    `json:"errcode"`
+	ErrMsg        string `json:"errmsg"`
+	PermanentCode string `json:"permanent_code"`
+	AuthCorpInfo  struct {
+		CorpID   string `json:"corpid"`
+		CorpName string `json:"corp_name"`
+	} `json:"auth_corp_info"`
+	AuthInfo struct {
+		Agent []struct {
+			AgentID int64  `json:"agentid"`
+			Name    string `json:"name"`
+		} `json:"agent"`
+	} `json:"auth_info"`
+}
+
+// agentID returns the agent ID of the suite in the corp, a suite has one agent per corp
+func (r *authInfoResponse) agentID() int64 {
+	if len(r.AuthInfo.Agent) == 0 {
+		return 0
+	}
+	return r.AuthInfo.Agent[0].AgentID
+}
@@ -0,0 +1,129 @@
+package suite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the latest suite ticket and the permanent codes of authorized corps.
+// Both survive restarts: the ticket is pushed only every 10 minutes and permanent codes only once.
+type Store interface {
+	Ticket() (string, error)
+	SetTicket(ticket string) error
+	// Auth returns the authorization of corpID, ok is false if the corp has not installed the suite
+	Auth(corpID string) (auth Auth, ok bool, err error)
+	SetAuth(auth Auth) error
+	DeleteAuth(corpID string) error
+}
+
+// FileStore keeps the state of one suite in a JSON file, replaced atomically on every change.
+// Replicas may share the file on one host; concurrent writers are serialized only within a process.
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// fileState is the content of the store file
+type fileState struct {
+	SuiteTicket string          `json:"suite_ticket"`
+	Auths       map[string]Auth `json:"auths"`
+}
+
+// NewFileStore creates a store of suiteID in dir, creating the directory if needed
+func NewFileStore(dir string, suiteID string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("suite store directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create suite store directory: %v", err)
+	}
+	return &FileStore{path: filepath.Join(dir, "suite-"+suiteID+".json")}, nil
+}
+
+// Ticket implements Store
+func (s *FileStore) Ticket() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, err := s.load()
+	return state.SuiteTicket, err
+}
+
+// SetTicket implements Store
+func (s *FileStore) SetTicket(ticket string) error {
+	return s.update(func(state *fileState) { state.SuiteTicket = ticket })
+}
+
+// Auth implements Store
+func (s *FileStore) Auth(corpID string) (Auth, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, err := s.load()
+	if err != nil {
+		return Auth{}, false, err
+	}
+	auth, ok := state.Auths[corpID]
+	return auth, ok, nil
+}
+
+// SetAuth implements Store
+func (s *FileStore) SetAuth(auth Auth) error {
+	return s.update(func(state *fileState) { state.Auths[auth.CorpID] = auth })
+}
+
+// DeleteAuth implements Store
+func (s *FileStore) DeleteAuth(corpID string) error {
+	return s.update(func(state *fileState) { delete(state.Auths, corpID) })
+}
+
+// update applies fn to the stored state and writes it back
+func (s *FileStore) update(fn func(state *fileState)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, err := s.load()
+	if err != nil {
+		return err
+	}
+	fn(&state)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal suite state: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".suite-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write suite state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write suite state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace suite state: %v", err)
+	}
+	return nil
+}
+
+// load reads the stored state, a missing file is an empty state
+func (s *FileStore) load() (fileState, error) {
+	state := fileState{Auths: map[string]Auth{}}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, fmt.Errorf("failed to read suite state: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to unmarshal suite state: %v", err)
+	}
+	if state.Auths == nil {
+		state.Auths = map[string]Auth{}
+	}
+	return state, nil
+}
@@ -0,0 +1,223 @@
+package suite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"example.com/play/repo/wecom/cron"
+	"example.com/play/utils/redact"
+)
+
+// Suite is a third-party app of a service provider installed by several corps.
+// The suite access token is obtained with the latest suite ticket, and the access token of
+// each authorized corp through the suite access token and the permanent code of the corp.
+type Suite struct {
+	id         string
+	secret     string
+	store      Store
+	tokens     *cron.Manager
+	token      *cron.Source
+	httpClient *http.Client
+}
+
+// New creates a suite whose tokens are refreshed by tokens
+func New(id string, secret string, store Store, tokens *cron.Manager) *Suite {
+	s := &Suite{id: id, secret: secret, store: store, tokens: tokens, httpClient: &http.Client{Timeout: 10 * time.Second}}
+	s.token = tokens.CustomSource("suite "+id, suiteTokenKeyPrefix+id, s.fetchSuiteToken)
+	return s
+}
+
+// ID returns the suite ID, which is also the receiver ID of instruction callbacks
+func (s *Suite) ID() string {
+	return s.id
+}
+
+// Token returns the source of the suite access token
+func (s *Suite) Token() *cron.Source {
+	return s.token
+}
+
+// Auth returns the authorization of corpID, ok is false if the corp has not installed the suite
+func (s *Suite) Auth(corpID string) (Auth, bool, error) {
+	return s.store.Auth(corpID)
+}
+
+// CorpToken returns the source of the access token of an authorized corp
+func (s *Suite) CorpToken(corpID string) *cron.Source {
+	return s.tokens.CustomSource("corp "+corpID+" of suite "+s.id, corpTokenKeyPrefix+s.id+":"+corpID, func() (*cron.AccessTokenResponse, error) {
+		return s.fetchCorpToken(corpID)
+	})
+}
+
+// HandleInstruction handles an instruction callback: saves the suite ticket, exchanges the auth code of a new
+// corp for its permanent code, refreshes the authorization of a changed corp or removes an uninstalled corp
+func (s *Suite) HandleInstruction(ins *Instruction) error {
+	if ins.SuiteID != s.id {
+		return fmt.Errorf("instruction of suite %s sent to suite %s", ins.SuiteID, s.id)
+	}
+	switch ins.InfoType {
+	case InfoTypeSuiteTicket:
+		if err := s.store.SetTicket(ins.SuiteTicket); err != nil {
+			return err
+		}
+		// the suite token cannot be fetched before the first ticket arrives, fetch it now instead of after the backoff.
+		// A failure is retried in the background and must not fail the callback, the ticket is saved already.
+		if !s.token.Status().Healthy() {
+			s.token.ForceRefresh()
+		}
+		return nil
+	case InfoTypeCreateAuth:
+		return s.createAuth(ins.AuthCode)
+	case InfoTypeChangeAuth:
+		return s.changeAuth(ins.AuthCorpID)
+	case InfoTypeCancelAuth:
+		log.Printf("Suite %s uninstalled by corp %s", s.id, ins.AuthCorpID)
+		if err := s.store.DeleteAuth(ins.AuthCorpID); err != nil {
+			return err
+		}
+		s.CorpToken(ins.AuthCorpID).Close()
+		return nil
+	}
+	log.Printf("Ignore instruction %s of suite %s", ins.InfoType, s.id)
+	return nil
+}
+
+func (s *Suite) createAuth(authCode string) error {
+	var result authInfoResponse
+	if err := s.postWithSuiteToken(WxPermanentCodeURL, permanentCodeRequest{AuthCode: authCode}, &result); err != nil {
+		return fmt.Errorf("failed to get permanent code: %v", err)
+	}
+	auth := Auth{
+		CorpID:        result.AuthCorpInfo.CorpID,
+		CorpName:      result.AuthCorpInfo.CorpName,
+		AgentID:       result.agentID(),
+		PermanentCode: result.PermanentCode,
+	}
+	if auth.CorpID == "" || auth.PermanentCode == "" {
+		return errors.New("failed to get permanent code: empty corpid or permanent_code")
+	}
+	log.Printf("Suite %s installed by corp %s (%s), agent: %d", s.id, auth.CorpID, auth.CorpName, auth.AgentID)
+	return s.store.SetAuth(auth)
+}
+
+func (s *Suite) changeAuth(corpID string) error {
+	auth, ok, err := s.store.Auth(corpID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("corp %s has not installed suite %s", corpID, s.id)
+	}
+	var result authInfoResponse
+	req := corpRequest{AuthCorpID: corpID, PermanentCode: auth.PermanentCode}
+	if err := s.postWithSuiteToken(WxAuthInfoURL, req, &result); err != nil {
+		return fmt.Errorf("failed to get auth info: %v", err)
+	}
+	auth.CorpName = result.AuthCorpInfo.CorpName
+	if agentID := result.agentID(); agentID != 0 {
+		auth.AgentID = agentID
+	}
+	log.Printf("Suite %s authorization changed by corp %s (%s), agent: %d", s.id, auth.CorpID, auth.CorpName, auth.AgentID)
+	return s.store.SetAuth(auth)
+}
+
+// fetchSuiteToken calls get_suite_token with the latest suite ticket
+func (s *Suite) fetchSuiteToken() (*cron.AccessTokenResponse, error) {
+	ticket, err := s.store.Ticket()
+	if err != nil {
+		return nil, err
+	}
+	if ticket == "" {
+		return nil, errors.New("no suite ticket received yet")
+	}
+	var result suiteTokenResponse
+	req := suiteTokenRequest{SuiteID: s.id, SuiteSecret: s.secret, SuiteTicket: ticket}
+	if err := s.post(WxSuiteTokenURL, req, &result); err != nil {
+		return nil, fmt.Errorf("failed to get suite access token: %v", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, fmt.Errorf("error getting suite access token: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return &cron.AccessTokenResponse{AccessToken: result.SuiteAccessToken, ExpiresIn: result.ExpiresIn}, nil
+}
+
+// fetchCorpToken calls get_corp_token with the permanent code of the corp
+func (s *Suite) fetchCorpToken(corpID string) (*cron.AccessTokenResponse, error) {
+	auth, ok, err := s.store.Auth(corpID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("corp %s has not installed suite %s", corpID, s.id)
+	}
+	var result cron.AccessTokenResponse
+	req := corpRequest{AuthCorpID: corpID, PermanentCode: auth.PermanentCode}
+	if err := s.postWithSuiteToken(WxCorpTokenURL, req, &result); err != nil {
+		return nil, fmt.Errorf("failed to get corp access token: %v", err)
+	}
+	return &result, nil
+}
+
+// postWithSuiteToken posts req to apiURL with the suite access token.
+// If the token is rejected, it forces a token refresh and retries once. A non-zero errcode is returned as error.
+func (s *Suite) postWithSuiteToken(apiURL string, req interface{}, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := s.token.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get suite access token: %v", err)
+		}
+		var status struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		raw := json.RawMessage{}
+		if err := s.post(apiURL+"?suite_access_token="+url.QueryEscape(token), req, &raw); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &status); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		if attempt == 0 && cron.IsTokenInvalid(status.ErrCode) {
+			log.Printf("Suite access token rejected with errcode %d, refresh and retry", status.ErrCode)
+			if err := s.token.ForceRefresh(); err == nil {
+				continue
+			}
+		}
+		if status.ErrCode != 0 {
+			return fmt.Errorf("API error: %d %s", status.ErrCode, status.ErrMsg)
+		}
+		if err := json.Unmarshal(raw, result); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		return nil
+	}
+}
+
+// post posts req as JSON and unmarshals the response into result
+func (s *Suite) post(apiURL string, req interface{}, result interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	resp, err := s.httpClient.Post(apiURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// mask the suite access token in the URL
+		return redact.Error(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
@@ -138,7 +138,16 @@ func (self *WXBizMsgCrypt) pKCS7Unpadding(plaintext []byte, block_size int) ([]b
 	if plaintext_len%block_size != 0 {
 		return nil, NewCryptError(DecryptAESError, "pKCS7Unpadding text not a multiple of the block size")
 	}
+	// 使用错误的 EncodingAESKey 解密时得到的是随机数据，需校验填充而不能直接截取
 	padding_len := int(plaintext[plaintext_len-1])
+	if padding_len < 1 || padding_len > block_size || padding_len > plaintext_len {
+		return nil, NewCryptError(DecryptAESError, "pKCS7Unpadding invalid padding")
+	}
+	for _, b := range plaintext[plaintext_len-padding_len:] {
+		if int(b) != padding_len {
+			return nil, NewCryptError(DecryptAESError, "pKCS7Unpadding invalid padding")
+		}
+	}
 	return plaintext[:plaintext_len-padding_len], nil
 }
 
@@ -227,7 +236,8 @@ func (self *WXBizMsgCrypt) ParsePlainText(plaintext []byte) ([]byte, uint32, []b
 	}
 	random := plaintext[:16]
 	msg_len := binary.BigEndian.Uint32(plaintext[16:20])
-	if text_len < (20 + msg_len) {
+	// 先减后比较，避免 20+msg_len 溢出
+	if msg_len > text_len-20 {
 		return nil, 0, nil, nil, NewCryptError(IllegalBuffer, "plain is to small 2")
 	}
 
@@ -255,7 +265,6 @@ func (self *WXBizMsgCrypt) VerifyURL(msg_signature, timestamp, nonce, echostr st
 	}
 
 	if len(self.receiver_id) > 0 && strings.Compare(string(receiver_id), self.receiver_id) != 0 {
-		fmt.Println(string(receiver_id), self.receiver_id, len(receiver_id), len(self.receiver_id))
 		return nil, NewCryptError(ValidateCorpidError, "receiver_id is not equil")
 	}
 
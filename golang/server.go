@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"example.com/play/config"
+	"example.com/play/logic"
+	"example.com/play/utils/redact"
+	"example.com/play/utils/tlscert"
+)
+
+// reloadRequests 管理服务的重载请求，重载结果写回请求携带的通道
+var reloadRequests = make(chan chan error)
+
+// startServers 启动回调服务和可选的内部管理服务，配置了 TLS 证书时两者都使用 HTTPS
+func startServers(cfg *config.GlobalConfig) ([]*http.Server, error) {
+	var certs *tlscert.Reloader
+	if cfg.TLSCertFile != "" {
+		var err error
+		if certs, err = tlscert.New(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return nil, err
+		}
+		certs.Watch(watchInterval)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", logic.CallbackHandler)
+	mux.HandleFunc("/healthz", logic.HealthHandler)
+	addr := cfg.Listen
+	if certs != nil && cfg.Source("listen") == "default" {
+		addr = ":443"
+	}
+	servers := []*http.Server{newServer(cfg, addr, mux, certs)}
+
+	if cfg.AdminListen != "" {
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/healthz", logic.HealthHandler)
+		adminMux.HandleFunc("/reload", reloadHandler)
+		// 配置校验保证管理服务使用双向 TLS
+		pool, err := loadClientCAs(cfg.AdminClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		admin := newServer(cfg, cfg.AdminListen, adminMux, certs)
+		admin.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		admin.TLSConfig.ClientCAs = pool
+		servers = append(servers, admin)
+	}
+
+	for i, server := range servers {
+		name := "Server"
+		if i > 0 {
+			name = "Admin server"
+		}
+		go serve(name, server)
+	}
+	return servers, nil
+}
+
+// newServer 创建带有超时和请求体大小限制的 http.Server，certs 不为 nil 时使用 HTTPS
+func newServer(cfg *config.GlobalConfig, addr string, handler http.Handler, certs *tlscert.Reloader) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           http.MaxBytesHandler(handler, int64(cfg.MaxBodyBytes)),
+		ReadHeaderTimeout: time.Duration(cfg.HTTPReadTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.HTTPReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.HTTPWriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.HTTPIdleTimeout) * time.Second,
+		ErrorLog:          log.Default(),
+	}
+	if certs != nil {
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+	return server
+}
+
+// serve 在后台运行 server，监听失败时退出进程
+func serve(name string, server *http.Server) {
+	var err error
+	if server.TLSConfig != nil {
+		log.Printf("%s started on %s (HTTPS)", name, server.Addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("%s started on %s", name, server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// loadClientCAs 读取校验管理服务客户端证书的 CA
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in admin client CA file %s", file)
+	}
+	return pool, nil
+}
+
+// reloadHandler 管理接口：POST /reload 立即重新加载配置，与 SIGHUP 相同，返回加载结果
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	done := make(chan error, 1)
+	reloadRequests <- done
+	if err := <-done; err != nil {
+		http.Error(w, redact.String(err.Error()), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "reloaded")
+}
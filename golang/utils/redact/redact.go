@@ -0,0 +1,93 @@
+// Package redact 遮盖日志和错误信息中的密钥和 access token。
+// 已登记的密钥值、URL 中携带凭证的查询参数（access_token、corpsecret 等）和 URL 中的密码都会替换为 ***。
+package redact
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mask 替换密钥的文本
+const Mask = "***"
+
+// minSecretLength 登记的密钥短于该长度时不遮盖，避免误伤普通文本
+const minSecretLength = 6
+
+var (
+	// queryPattern 携带凭证的查询参数，如 access_token=xxx、corpsecret=xxx
+	queryPattern = regexp.MustCompile(`(?i)([?&](?:[a-z_]*access_token|[a-z_]*secret|[a-z_]*ticket|permanent_code|auth_code)=)[^&\s"']*`)
+	// passwordPattern URL 中的密码，如 redis://:password@host
+	passwordPattern = regexp.MustCompile(`(://[^:/@\s]*:)[^@/\s]+@`)
+
+	mutex    sync.Mutex
+	secrets  = map[string]bool{}
+	replacer atomic.Pointer[strings.Replacer]
+)
+
+// Register 登记需要遮盖的密钥值，重复登记和过短的值会被忽略
+func Register(values ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	added := false
+	for _, v := range values {
+		if len(v) >= minSecretLength && !secrets[v] {
+			secrets[v] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	// 较长的密钥优先替换，避免一个密钥是另一个的前缀时只遮盖一部分
+	list := make([]string, 0, len(secrets))
+	for v := range secrets {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, 2*len(list))
+	for _, v := range list {
+		pairs = append(pairs, v, Mask)
+	}
+	replacer.Store(strings.NewReplacer(pairs...))
+}
+
+// String 遮盖 s 中的密钥、凭证查询参数和 URL 密码
+func String(s string) string {
+	if r := replacer.Load(); r != nil {
+		s = r.Replace(s)
+	}
+	s = queryPattern.ReplaceAllString(s, "${1}"+Mask)
+	return passwordPattern.ReplaceAllString(s, "${1}"+Mask+"@")
+}
+
+// Error 返回遮盖了请求 URL 的错误，http.Client 返回的 *url.Error 会带上完整的请求 URL
+func Error(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: String(urlErr.URL), Err: urlErr.Err}
+}
+
+// writer 写入前遮盖密钥的 io.Writer
+type writer struct {
+	w io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writer 返回写入 w 前遮盖密钥的 io.Writer
+func Writer(w io.Writer) io.Writer {
+	return writer{w: w}
+}
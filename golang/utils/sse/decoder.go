@@ -0,0 +1,186 @@
+// Package sse 按照 WHATWG HTML 标准中 event-stream 的解析规则解码 Server-Sent Events。
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxEventSize 默认单个事件的最大字节数
+const DefaultMaxEventSize = 8 << 20
+
+// ErrEventTooLarge 单个事件超过最大字节数
+var ErrEventTooLarge = errors.New("sse: event too large")
+
+// Event 一个完整的事件
+type Event struct {
+	ID    string        // 最近一次 id 字段的值，未出现时沿用之前事件的 id
+	Type  string        // event 字段的值，未设置时为 "message"
+	Data  string        // 多个 data 字段以换行连接
+	Retry time.Duration // 最近一次有效 retry 字段的值，未出现过时为 0
+}
+
+// Decoder 从输入流中逐个读取事件
+type Decoder struct {
+	r            *bufio.Reader
+	maxEventSize int
+
+	lastEventID string
+	retry       time.Duration
+	skipLF      bool // 上一行以 CR 结束，紧随其后的 LF 属于同一个换行
+	bomChecked  bool
+
+	line      []byte
+	data      bytes.Buffer
+	eventType string
+}
+
+// Option 解码器配置项
+type Option func(*Decoder)
+
+// WithMaxEventSize 设置单个事件（含未分发的 data 和当前行）的最大字节数，默认 DefaultMaxEventSize
+func WithMaxEventSize(n int) Option {
+	return func(d *Decoder) { d.maxEventSize = n }
+}
+
+// NewDecoder 创建解码器
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	d := &Decoder{
+		r:            bufio.NewReader(r),
+		maxEventSize: DefaultMaxEventSize,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Decode 读取下一个事件。输入流结束时返回 io.EOF，未以空行结束的残余数据按标准丢弃。
+func (d *Decoder) Decode() (*Event, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		// 空行：分发事件
+		if len(line) == 0 {
+			if d.data.Len() == 0 {
+				d.eventType = ""
+				continue
+			}
+			data := d.data.Bytes()
+			data = bytes.TrimSuffix(data, []byte{'\n'})
+			ev := &Event{
+				ID:    d.lastEventID,
+				Type:  d.eventType,
+				Data:  string(data),
+				Retry: d.retry,
+			}
+			if ev.Type == "" {
+				ev.Type = "message"
+			}
+			d.data.Reset()
+			d.eventType = ""
+			return ev, nil
+		}
+
+		// 以冒号开头的是注释
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte{' '})
+		}
+		if err := d.processField(string(field), value); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *Decoder) processField(field string, value []byte) error {
+	switch field {
+	case "event":
+		d.eventType = string(value)
+	case "data":
+		if d.data.Len()+len(value)+1 > d.maxEventSize {
+			return ErrEventTooLarge
+		}
+		d.data.Write(value)
+		d.data.WriteByte('\n')
+	case "id":
+		if bytes.IndexByte(value, 0) < 0 {
+			d.lastEventID = string(value)
+		}
+	case "retry":
+		if ms, ok := parseDigits(value); ok {
+			d.retry = time.Duration(ms) * time.Millisecond
+		}
+	default:
+		// 未知字段忽略
+	}
+	return nil
+}
+
+// readLine 读取一行，行尾可以是 CRLF、LF 或 CR，返回的切片在下次调用前有效
+func (d *Decoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(d.line) > 0 {
+				// 最后一行没有换行，所在事件不完整
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\n':
+			return d.stripBOM(d.line), nil
+		case '\r':
+			d.skipLF = true
+			return d.stripBOM(d.line), nil
+		}
+		d.line = append(d.line, b)
+		if d.data.Len()+len(d.line) > d.maxEventSize {
+			return nil, fmt.Errorf("%w: line exceeds %d bytes", ErrEventTooLarge, d.maxEventSize)
+		}
+	}
+}
+
+// stripBOM 去掉流开头的 UTF-8 BOM
+func (d *Decoder) stripBOM(line []byte) []byte {
+	if d.bomChecked {
+		return line
+	}
+	d.bomChecked = true
+	return bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+}
+
+func parseDigits(value []byte) (int64, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	return n, err == nil
+}
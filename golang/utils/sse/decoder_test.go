@@ -0,0 +1,169 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// chunkReader 按给定的分段返回数据，模拟网络上一次次到达的读取
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		opts    []Option
+		want    []Event
+		wantErr error
+	}{
+		{
+			name:   "multi-line data",
+			chunks: []string{"data: a\ndata:b\ndata\ndata:  c\n\n"},
+			want:   []Event{{Type: "message", Data: "a\nb\n\n c"}},
+		},
+		{
+			name:   "event type and empty events",
+			chunks: []string{"event: reply\n\nevent: reply\ndata: a\n\ndata: b\n\n"},
+			want:   []Event{{Type: "reply", Data: "a"}, {Type: "message", Data: "b"}},
+		},
+		{
+			name:   "line endings",
+			chunks: []string{"data: lf\n\ndata: cr\r\rdata: crlf\r\n\r\ndata: a\r\ndata: b\rdata: c\n\n"},
+			want: []Event{
+				{Type: "message", Data: "lf"},
+				{Type: "message", Data: "cr"},
+				{Type: "message", Data: "crlf"},
+				{Type: "message", Data: "a\nb\nc"},
+			},
+		},
+		{
+			// CRLF 被拆在两次读取中，不能当作两个换行而提前分发事件
+			name:   "CRLF split across reads",
+			chunks: []string{"data: a\r", "\ndata: b\r", "\n\r", "\n"},
+			want:   []Event{{Type: "message", Data: "a\nb"}},
+		},
+		{
+			name:   "comments",
+			chunks: []string{": ping\ndata: a\n:\n\n: keep-alive\n\n"},
+			want:   []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "id containing NUL ignored",
+			chunks: []string{"id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\ndata: c\n\nid\ndata: d\n\n"},
+			want: []Event{
+				{ID: "1", Type: "message", Data: "a"},
+				{ID: "1", Type: "message", Data: "b"},
+				{ID: "1", Type: "message", Data: "c"},
+				{ID: "", Type: "message", Data: "d"},
+			},
+		},
+		{
+			name:   "retry with non-digits ignored",
+			chunks: []string{"retry: 3000\ndata: a\n\nretry: 1x\ndata: b\n\nretry: -1\nretry: 1.5\nretry:\ndata: c\n\n"},
+			want: []Event{
+				{Type: "message", Data: "a", Retry: 3 * time.Second},
+				{Type: "message", Data: "b", Retry: 3 * time.Second},
+				{Type: "message", Data: "c", Retry: 3 * time.Second},
+			},
+		},
+		{
+			name:   "BOM stripped on first line",
+			chunks: []string{"\xEF\xBB\xBFdata: a\n\n"},
+			want:   []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			// 后续行开头的 BOM 是字段名的一部分，该字段未知而被忽略
+			name:   "BOM kept on later lines",
+			chunks: []string{"data: a\n\n\xEF\xBB\xBFdata: b\n\n"},
+			want:   []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "unterminated trailing event discarded",
+			chunks: []string{"data: a\n\ndata: b\n"},
+			want:   []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "trailing line without line ending discarded",
+			chunks: []string{"data: a\n\ndata: b"},
+			want:   []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:    "data exceeds max event size",
+			chunks:  []string{"data: 0123456789\n\ndata: 0123456789\ndata: 0123456789\n\n"},
+			opts:    []Option{WithMaxEventSize(20)},
+			want:    []Event{{Type: "message", Data: "0123456789"}},
+			wantErr: ErrEventTooLarge,
+		},
+		{
+			name:    "line exceeds max event size",
+			chunks:  []string{"data: 0123456789\n\ndata: 0123456789012345678901234567890123456789\n\n"},
+			opts:    []Option{WithMaxEventSize(20)},
+			want:    []Event{{Type: "message", Data: "0123456789"}},
+			wantErr: ErrEventTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantErr := tt.wantErr
+			if wantErr == nil {
+				wantErr = io.EOF
+			}
+			d := NewDecoder(&chunkReader{chunks: append([]string(nil), tt.chunks...)}, tt.opts...)
+			var got []Event
+			var err error
+			for {
+				var ev *Event
+				if ev, err = d.Decode(); err != nil {
+					break
+				}
+				got = append(got, *ev)
+			}
+			if !errors.Is(err, wantErr) {
+				t.Errorf("error: %v, want %v", err, wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDecoderSmallReads 逐字节读取与一次读取的结果相同
+func TestDecoderSmallReads(t *testing.T) {
+	input := "\xEF\xBB\xBFid: 7\r\nevent: reply\r\ndata: a\r\ndata: b\r\n\r\n: ping\rretry: 500\rdata: c\r\r"
+	d := NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+	want := []Event{
+		{ID: "7", Type: "reply", Data: "a\nb"},
+		{ID: "7", Type: "message", Data: "c", Retry: 500 * time.Millisecond},
+	}
+	for _, w := range want {
+		ev, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *ev != w {
+			t.Errorf("event: %+v, want %+v", *ev, w)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("error: %v, want EOF", err)
+	}
+}
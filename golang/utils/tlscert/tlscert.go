@@ -0,0 +1,90 @@
+// Package tlscert 从文件加载 TLS 证书和私钥，文件修改后自动重新加载，更换证书无需重启服务。
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader 提供最近一次成功加载的证书，用于 tls.Config 的 GetCertificate
+type Reloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	// modTime 最近一次成功加载时两个文件中较晚的修改时间，只由 Watch 的协程在加载后修改
+	modTime time.Time
+}
+
+// New 加载证书和私钥，失败时返回错误
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate 返回当前证书
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Watch 每隔 interval 检查一次证书和私钥文件，修改后重新加载。
+// 加载失败（如证书和私钥只更新了一个）时继续使用原有证书，下次检查时重试。
+func (r *Reloader) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			modTime, err := r.latestModTime()
+			if err != nil {
+				log.Printf("Stat TLS certificate failed, keep previous certificate, err: %v", err)
+				continue
+			}
+			if modTime.Equal(r.modTime) {
+				continue
+			}
+			if err := r.load(modTime); err != nil {
+				log.Printf("Reload TLS certificate failed, keep previous certificate, err: %v", err)
+			}
+		}
+	}()
+}
+
+// load 加载证书和私钥，成功后记录文件的修改时间
+func (r *Reloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	expires := ""
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		expires = ", expires at " + leaf.NotAfter.Format(time.RFC3339)
+	}
+	log.Printf("TLS certificate loaded from %s%s", r.certFile, expires)
+	return nil
+}
+
+// latestModTime 返回证书和私钥文件中较晚的修改时间
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}